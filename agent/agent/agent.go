@@ -1,74 +1,214 @@
 package agent
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
-	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-// worker is a goroutine that continuously requests tasks.
-func worker(workerID int) {
-	client := &http.Client{}
-	for {
-		resp, err := client.Get(fmt.Sprintf("http://localhost:%s/internal/task", OrchestratorPort))
+// worker is a goroutine that continuously requests tasks until ctx is done.
+// The orchestrator holds each request for up to PollWaitMs until a task is ready.
+// A task in progress is computed until finishCtx is done; if it doesn't finish by then, it is handed back.
+func worker(ctx, finishCtx context.Context, workerID int, client *orchestratorClient) {
+	idleSince := time.Now()
+	for ctx.Err() == nil {
+		resp, err := client.do(ctx, http.MethodGet, fmt.Sprintf("/internal/task?wait=%dms&agent_id=%s", PollWaitMs, url.QueryEscape(AgentID)), nil)
 		if err != nil {
-			time.Sleep(1 * time.Second)
+			if ctx.Err() == nil {
+				workerErrors.WithLabelValues(workerLabel(workerID), "orchestrator").Inc()
+			}
+			sleep(ctx, 1*time.Second)
 			continue
 		}
 		if resp.StatusCode == http.StatusNotFound {
-			// No tasks available
+			// No tasks became available while waiting
 			resp.Body.Close()
-			time.Sleep(500 * time.Millisecond)
+			if PollWaitMs == 0 {
+				sleep(ctx, 500*time.Millisecond)
+			}
 			continue
 		}
 		var taskResp struct {
 			Task struct {
-				ID            string  `json:"id"`
-				Arg1          float64 `json:"arg1"`
-				Arg2          float64 `json:"arg2"`
-				Operation     string  `json:"operation"`
-				OperationTime int     `json:"operation_time"`
+				ID            string    `json:"id"`
+				ExpressionID  string    `json:"expression_id"`
+				LeaseID       string    `json:"lease_id"`
+				Args          []float64 `json:"args"`
+				Operation     string    `json:"operation"`
+				OperationTime int       `json:"operation_time"`
 			} `json:"task"`
 		}
 		if err := json.NewDecoder(resp.Body).Decode(&taskResp); err != nil {
+			workerErrors.WithLabelValues(workerLabel(workerID), "orchestrator").Inc()
 			resp.Body.Close()
-			time.Sleep(500 * time.Millisecond)
+			sleep(ctx, 500*time.Millisecond)
 			continue
 		}
 		resp.Body.Close()
 		task := taskResp.Task
-		// Simulate long computation time
-		time.Sleep(time.Duration(task.OperationTime) * time.Millisecond)
-		// Compute the operation using EvaluateOperation from the calculator package.
-		result, err := calculator.EvaluateOperation(task.Operation, task.Arg1, task.Arg2)
-		if err != nil {
-			log.Printf("Worker %d: error computing task %s: %v", workerID, task.ID, err)
-			continue
+		// Spans of the task belong to the trace of its expression, started by the orchestrator
+		taskCtx := propagator.Extract(finishCtx, propagation.HeaderCarrier(resp.Header))
+		observeIdle(workerID, idleSince)
+		taskLog := taskLogger(workerID, task.ID, task.ExpressionID)
+		taskLog.Debug("task received", "operation", task.Operation)
+		result, err := computeTask(taskCtx, workerID, task.ID, task.Operation, task.Args, task.OperationTime)
+		// The outcome is reported even if the agent is shutting down
+		submitCtx, submitSpan := startSubmitSpan(context.WithoutCancel(taskCtx), task.ID)
+		switch {
+		case errors.Is(err, context.Canceled):
+			// The agent is shutting down and the task didn't finish in time
+			taskLog.Info("handing task back")
+			if err := handBackTask(submitCtx, client, task.ID, task.LeaseID); err != nil {
+				setSpanError(submitSpan, err)
+				taskLog.Error("handing task back", "error", err)
+				workerErrors.WithLabelValues(workerLabel(workerID), "orchestrator").Inc()
+			}
+		case err != nil:
+			taskLog.Warn("computing task", "error", err)
+			workerErrors.WithLabelValues(workerLabel(workerID), "compute").Inc()
+			// Report the error so the orchestrator can fail the expression.
+			if err := postTask(submitCtx, client, map[string]any{"id": task.ID, "lease_id": task.LeaseID, "error": err.Error()}); err != nil {
+				setSpanError(submitSpan, err)
+				reportFailed(taskLog, workerID, "reporting task failure", err)
+			}
+		default:
+			// Send the result back to the orchestrator.
+			err := postTask(submitCtx, client, map[string]any{
+				"id":       task.ID,
+				"lease_id": task.LeaseID,
+				"result":   result,
+			})
+			if err != nil {
+				setSpanError(submitSpan, err)
+				reportFailed(taskLog, workerID, "posting task result", err)
+			} else {
+				taskLog.Debug("task completed", "result", result)
+				tasksCompleted.WithLabelValues(workerLabel(workerID)).Inc()
+			}
 		}
-		// Send the result back to the orchestrator.
-		payload, _ := json.Marshal(map[string]any{
-			"id":     task.ID,
-			"result": result,
-		})
-		_, err = client.Post(fmt.Sprintf("http://localhost:%s/internal/task", OrchestratorPort), "application/json", bytes.NewBuffer(payload))
+		submitSpan.End()
+		idleSince = time.Now()
+	}
+}
+
+// errRejected is returned when the orchestrator refuses the outcome of a task because the task
+// is no longer the agent's: its lease expired, or it was cancelled or deleted.
+var errRejected = errors.New("rejected by the orchestrator")
+
+// postTask sends a task result (or error) back to the orchestrator.
+func postTask(ctx context.Context, client *orchestratorClient, body map[string]any) error {
+	payload, _ := json.Marshal(body)
+	resp, err := client.do(ctx, http.MethodPost, "/internal/task", payload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusNotFound, http.StatusConflict, http.StatusGone:
+		return fmt.Errorf("%w: %s", errRejected, resp.Status)
+	default:
+		return fmt.Errorf("orchestrator answered %s", resp.Status)
+	}
+}
+
+// reportFailed logs and counts a report of a task that didn't go through. Rejected reports are
+// expected, e.g. for tasks of cancelled expressions, and are counted apart from errors.
+func reportFailed(taskLog *slog.Logger, workerID int, msg string, err error) {
+	if errors.Is(err, errRejected) {
+		taskLog.Warn(msg, "error", err)
+		tasksRejected.WithLabelValues(workerLabel(workerID)).Inc()
+		return
+	}
+	taskLog.Error(msg, "error", err)
+	workerErrors.WithLabelValues(workerLabel(workerID), "orchestrator").Inc()
+}
+
+// handBackTask returns a task the agent won't compute to the orchestrator, to be handed out to another agent.
+func handBackTask(ctx context.Context, client *orchestratorClient, id, leaseID string) error {
+	payload, _ := json.Marshal(map[string]string{"id": id, "lease_id": leaseID})
+	resp, err := client.do(ctx, http.MethodPost, "/internal/task/release", payload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("orchestrator answered %s", resp.Status)
+	}
+	return nil
+}
+
+// sleep pauses for d or until ctx is done.
+func sleep(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// RunAgent runs the workers until ctx is done (e.g. on SIGTERM). Then the agent stops fetching tasks,
+// gives the tasks in progress up to ShutdownTimeoutMs to finish, hands back the others and returns.
+// It returns an error if the agent could not be started; such errors are logged before being returned.
+func RunAgent(ctx context.Context) error {
+	if MetricsPort != "" {
+		go serveMetrics(ctx)
+	}
+	// Tasks in progress are computed with finishCtx, which ends ShutdownTimeoutMs after ctx
+	finishCtx, cancelFinish := context.WithCancel(context.Background())
+	defer cancelFinish()
+	shutdownTimeout := time.Duration(ShutdownTimeoutMs) * time.Millisecond
+	stopFinishTimer := context.AfterFunc(ctx, func() {
+		time.AfterFunc(shutdownTimeout, cancelFinish)
+	})
+	defer stopFinishTimer()
+
+	var workers sync.WaitGroup
+	switch Transport {
+	case "http":
+		client, err := newOrchestratorClient(OrchestratorURLs)
 		if err != nil {
-			log.Printf("Worker %d: error posting result for task %s: %v", workerID, task.ID, err)
-			continue
+			logger.Error("creating orchestrator client", "error", err)
+			return err
 		}
-		log.Printf("Worker %d: completed task %s with result %f", workerID, task.ID, result)
+		register(ctx, client)
+		go sendHeartbeats(ctx, client)
+		for i := 0; i < ComputingPower; i++ {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				worker(ctx, finishCtx, i, client)
+			}()
+		}
+	case "grpc":
+		if err := runGRPCAgent(ctx, finishCtx, &workers); err != nil {
+			return err
+		}
+	default:
+		err := fmt.Errorf("unknown agent transport %q, expected http or grpc", Transport)
+		logger.Error("starting agent", "error", err)
+		return err
 	}
-}
+	logger.Info("agent started", "workers", ComputingPower, "transport", Transport)
 
-func RunAgent() {
-	for i := 0; i < ComputingPower; i++ {
-		go worker(i)
+	<-ctx.Done()
+	logger.Info("shutting down")
+	workers.Wait()
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(flushCtx); err != nil {
+		logger.Warn("exporting spans", "error", err)
 	}
-	fmt.Printf("Started agent with %d workers\n", ComputingPower)
-	// Infinite wait
-	select {}
+	logger.Info("agent stopped")
+	return nil
 }
@@ -0,0 +1,145 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPostTaskStatus(t *testing.T) {
+	for status, want := range map[int]string{
+		http.StatusOK:                  "accepted",
+		http.StatusNotFound:            "rejected",
+		http.StatusConflict:            "rejected",
+		http.StatusGone:                "rejected",
+		http.StatusUnprocessableEntity: "failed",
+		http.StatusInternalServerError: "failed",
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		client, err := newOrchestratorClient([]string{server.URL})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+		err = postTask(context.Background(), client, map[string]any{"id": "task1", "lease_id": "lease1", "result": 4})
+		server.Close()
+		got := "failed"
+		if err == nil {
+			got = "accepted"
+		} else if errors.Is(err, errRejected) {
+			got = "rejected"
+		}
+		if got != want {
+			t.Errorf("status %d: expected the result to be %s, got %v", status, want, err)
+		}
+	}
+}
+
+// signalWriter closes received once a log line about a received task is written.
+type signalWriter struct {
+	once     sync.Once
+	received chan struct{}
+}
+
+func (w *signalWriter) Write(p []byte) (int, error) {
+	if bytes.Contains(p, []byte(`"msg":"task received"`)) {
+		w.once.Do(func() { close(w.received) })
+	}
+	return len(p), nil
+}
+
+func TestRunAgentShutdown(t *testing.T) {
+	defer func(urls []string, power, timeout int, transport string, l *slog.Logger) {
+		OrchestratorURLs, ComputingPower, ShutdownTimeoutMs, Transport, logger = urls, power, timeout, transport, l
+	}(OrchestratorURLs, ComputingPower, ShutdownTimeoutMs, Transport, logger)
+	ComputingPower, Transport = 1, "http"
+
+	for _, tc := range []struct {
+		name              string
+		operationTime     int
+		shutdownTimeoutMs int
+		wantPath          string
+		wantBody          map[string]any
+	}{
+		{
+			name:          "in-flight result is posted",
+			operationTime: 200, shutdownTimeoutMs: 5000,
+			wantPath: "/internal/task",
+			wantBody: map[string]any{"id": "task1", "lease_id": "lease1", "result": 5.0},
+		},
+		{
+			name:          "unfinished task is handed back",
+			operationTime: 60000, shutdownTimeoutMs: 50,
+			wantPath: "/internal/task/release",
+			wantBody: map[string]any{"id": "task1", "lease_id": "lease1"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			type report struct {
+				path string
+				body map[string]any
+			}
+			var reports []report
+			var served atomic.Bool
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.Method == http.MethodGet && r.URL.Path == "/internal/task":
+					if served.Swap(true) {
+						http.Error(w, "no task", http.StatusNotFound)
+						return
+					}
+					json.NewEncoder(w).Encode(map[string]any{"task": map[string]any{
+						"id": "task1", "expression_id": "expr1", "lease_id": "lease1",
+						"args": []float64{2, 3}, "operation": "+", "operation_time": tc.operationTime,
+					}})
+				case r.URL.Path == "/internal/task" || r.URL.Path == "/internal/task/release":
+					var body map[string]any
+					json.NewDecoder(r.Body).Decode(&body)
+					mu.Lock()
+					reports = append(reports, report{r.URL.Path, body})
+					mu.Unlock()
+				}
+			}))
+			defer server.Close()
+			OrchestratorURLs, ShutdownTimeoutMs = []string{server.URL}, tc.shutdownTimeoutMs
+			signal := &signalWriter{received: make(chan struct{})}
+			logger = newLogger(signal, "debug", "json")
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() { done <- RunAgent(ctx) }()
+			select {
+			case <-signal.received:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the agent didn't receive the task")
+			}
+			cancel()
+			select {
+			case err := <-done:
+				if err != nil {
+					t.Fatalf("agent failed: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("the agent didn't stop")
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(reports) != 1 || reports[0].path != tc.wantPath || !reflect.DeepEqual(reports[0].body, tc.wantBody) {
+				t.Errorf("expected a single POST %s with %v, got %+v", tc.wantPath, tc.wantBody, reports)
+			}
+		})
+	}
+}
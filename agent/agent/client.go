@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// orchestratorClient sends requests to the orchestrator. When the current endpoint
+// can't be reached, it fails over to the next one from OrchestratorURLs.
+type orchestratorClient struct {
+	http      *http.Client
+	endpoints []string
+	current   atomic.Int64 // index of the endpoint that answered last
+}
+
+// newOrchestratorClient validates the endpoints and creates a client for them.
+func newOrchestratorClient(endpoints []string) (*orchestratorClient, error) {
+	cleaned, err := parseEndpoints(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	return &orchestratorClient{http: &http.Client{}, endpoints: cleaned}, nil
+}
+
+// parseEndpoints validates orchestrator base URLs and strips trailing slashes.
+func parseEndpoints(endpoints []string) ([]string, error) {
+	if len(endpoints) == 0 {
+		return nil, fmt.Errorf("no orchestrator endpoints configured")
+	}
+	cleaned := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		u, err := url.Parse(endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid orchestrator url %q: %w", endpoint, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid orchestrator url %q: expected http(s)://host[:port][/base/path]", endpoint)
+		}
+		cleaned[i] = strings.TrimSuffix(u.String(), "/")
+	}
+	return cleaned, nil
+}
+
+// do sends the request to the current endpoint, trying the others in turn if it is unreachable
+// or answers that it is unavailable. path is relative to the endpoint base path.
+// The current span of ctx, if any, is propagated in the traceparent header.
+func (c *orchestratorClient) do(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	start := int(c.current.Load())
+	var lastErr error
+	for i := 0; i < len(c.endpoints); i++ {
+		idx := (start + i) % len(c.endpoints)
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.endpoints[idx]+path, reader)
+		if err != nil {
+			return nil, err
+		}
+		propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := c.http.Do(req)
+		if err == nil && !isUnavailable(resp.StatusCode) {
+			c.current.Store(int64(idx))
+			return resp, nil
+		}
+		if err == nil {
+			resp.Body.Close()
+			err = fmt.Errorf("%s answered %s", c.endpoints[idx], resp.Status)
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// isUnavailable reports whether the status means the endpoint can't serve requests right now.
+func isUnavailable(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
@@ -0,0 +1,45 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOrchestratorClientFailover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+	var gotPath string
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer up.Close()
+
+	client, err := newOrchestratorClient([]string{down.URL, up.URL + "/calc/"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	resp, err := client.do(context.Background(), http.MethodGet, "/internal/task", nil)
+	if err != nil {
+		t.Fatalf("expected request to fail over to the second endpoint: %v", err)
+	}
+	resp.Body.Close()
+	if gotPath != "/calc/internal/task" {
+		t.Errorf("expected request under the base path, got %q", gotPath)
+	}
+	if client.current.Load() != 1 {
+		t.Errorf("expected client to stick to the working endpoint, got %d", client.current.Load())
+	}
+}
+
+func TestNewOrchestratorClientInvalidURL(t *testing.T) {
+	for _, endpoint := range []string{"localhost:8080", "ftp://localhost", "http://"} {
+		if _, err := newOrchestratorClient([]string{endpoint}); err == nil {
+			t.Errorf("expected error for %q", endpoint)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/taskservice/taskservice"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcClient calls TaskService of the orchestrator, failing over between
+// OrchestratorGRPCURLs like orchestratorClient does for HTTP.
+type grpcClient struct {
+	conns   []*grpc.ClientConn
+	clients []taskservice.TaskServiceClient
+	current atomic.Int64 // index of the endpoint that answered last
+}
+
+// newGRPCClient validates the endpoints and creates a client for them. An http:// endpoint is
+// dialed in plaintext and an https:// one with TLS; connections are made on the first call.
+func newGRPCClient(endpoints []string) (*grpcClient, error) {
+	cleaned, err := parseEndpoints(endpoints)
+	if err != nil {
+		return nil, err
+	}
+	c := &grpcClient{}
+	for _, endpoint := range cleaned {
+		u, _ := url.Parse(endpoint)
+		creds := insecure.NewCredentials()
+		if u.Scheme == "https" {
+			creds = credentials.NewTLS(&tls.Config{})
+		}
+		conn, err := grpc.NewClient(u.Host, grpc.WithTransportCredentials(creds))
+		if err != nil {
+			c.close()
+			return nil, fmt.Errorf("invalid orchestrator url %q: %w", endpoint, err)
+		}
+		c.conns = append(c.conns, conn)
+		c.clients = append(c.clients, taskservice.NewTaskServiceClient(conn))
+	}
+	return c, nil
+}
+
+// close closes the connections to the endpoints.
+func (c *grpcClient) close() {
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
+
+// call performs fn with the client of the current endpoint, trying the others in turn
+// while the call fails as unavailable.
+func (c *grpcClient) call(fn func(client taskservice.TaskServiceClient) error) error {
+	start := int(c.current.Load())
+	var err error
+	for i := 0; i < len(c.clients); i++ {
+		idx := (start + i) % len(c.clients)
+		err = fn(c.clients[idx])
+		if status.Code(err) != codes.Unavailable {
+			c.current.Store(int64(idx))
+			return err
+		}
+	}
+	return err
+}
+
+// outgoingContext returns ctx with the current span of ctx, if any, in the traceparent metadata.
+func outgoingContext(ctx context.Context) context.Context {
+	if value := traceParent(ctx); value != "" {
+		return metadata.AppendToOutgoingContext(ctx, traceParentHeader, value)
+	}
+	return ctx
+}
+
+// report sends the result or error of a task with fn. Like postTask, it returns errRejected
+// if the task is no longer the agent's.
+func (c *grpcClient) report(fn func(client taskservice.TaskServiceClient) error) error {
+	err := c.call(fn)
+	switch status.Code(err) {
+	case codes.NotFound, codes.Canceled, codes.Aborted:
+		return fmt.Errorf("%w: %v", errRejected, err)
+	}
+	return err
+}
+
+// registerGRPC announces the agent to the orchestrator, retrying until it succeeds or ctx is done.
+func registerGRPC(ctx context.Context, client *grpcClient) {
+	hostname, _ := os.Hostname()
+	req := &taskservice.RegisterRequest{
+		AgentId:    AgentID,
+		Hostname:   hostname,
+		Workers:    int32(ComputingPower),
+		Operations: calculator.Operations(),
+	}
+	for ctx.Err() == nil {
+		err := client.call(func(c taskservice.TaskServiceClient) error {
+			_, err := c.Register(ctx, req)
+			return err
+		})
+		if err == nil {
+			logger.Info("registered with the orchestrator")
+			return
+		}
+		if ctx.Err() == nil {
+			logger.Warn("registering with the orchestrator", "error", err)
+		}
+		sleep(ctx, 1*time.Second)
+	}
+}
+
+// receiveTasks keeps a Heartbeat stream open and hands the tasks pushed over it to the workers.
+// Workers report on freed when they finish a task, which lets the orchestrator push the next one.
+// It returns once ctx is done.
+func receiveTasks(ctx context.Context, client *grpcClient, tasks chan<- *taskservice.Task, freed <-chan struct{}) {
+	idle := ComputingPower
+	for ctx.Err() == nil {
+		registerGRPC(ctx, client)
+		err := heartbeatStream(ctx, client, tasks, freed, &idle)
+		if ctx.Err() == nil {
+			logger.Warn("heartbeat stream ended", "error", err)
+		}
+		sleep(ctx, 1*time.Second)
+	}
+}
+
+// heartbeatStream runs a single Heartbeat call until it fails. idle counts the workers without
+// a task; the orchestrator forgets about free workers when the call ends, so every call starts
+// by announcing all of them.
+func heartbeatStream(ctx context.Context, client *grpcClient, tasks chan<- *taskservice.Task, freed <-chan struct{}, idle *int) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var stream grpc.BidiStreamingClient[taskservice.HeartbeatRequest, taskservice.HeartbeatResponse]
+	err := client.call(func(c taskservice.TaskServiceClient) (err error) {
+		stream, err = c.Heartbeat(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer stream.CloseSend()
+
+	received := make(chan *taskservice.Task)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				// Unblock a Send to the ended call
+				cancel()
+				return
+			}
+			if resp.Task == nil {
+				continue
+			}
+			select {
+			case received <- resp.Task:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if err := stream.Send(&taskservice.HeartbeatRequest{AgentId: AgentID, FreeWorkers: int32(*idle)}); err != nil {
+		return err
+	}
+	ticker := time.NewTicker(time.Duration(HeartbeatIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		var free int32
+		select {
+		case task := <-received:
+			*idle--
+			tasks <- task
+			continue
+		case <-freed:
+			*idle++
+			free = 1
+		case <-ticker.C:
+		case err := <-recvErr:
+			return fmt.Errorf("receiving tasks: %w", err)
+		}
+		if err := stream.Send(&taskservice.HeartbeatRequest{AgentId: AgentID, FreeWorkers: free}); err != nil {
+			return err
+		}
+	}
+}
+
+// grpcWorker computes tasks pushed by the orchestrator and reports results over TaskService
+// until tasks is closed. Like worker, it hands back the tasks that don't finish before finishCtx is done.
+func grpcWorker(ctx, finishCtx context.Context, workerID int, client *grpcClient, tasks <-chan *taskservice.Task, freed chan<- struct{}) {
+	idleSince := time.Now()
+	for task := range tasks {
+		observeIdle(workerID, idleSince)
+		taskCtx := contextWithTraceParent(finishCtx, task.TraceParent)
+		taskLog := taskLogger(workerID, task.Id, task.ExpressionId)
+		taskLog.Debug("task received", "operation", task.Operation)
+		result, err := computeTask(taskCtx, workerID, task.Id, task.Operation, task.Args, int(task.OperationTime))
+		// The outcome is reported even if the agent is shutting down
+		submitCtx, submitSpan := startSubmitSpan(context.WithoutCancel(taskCtx), task.Id)
+		submitCtx = outgoingContext(submitCtx)
+		if errors.Is(err, context.Canceled) {
+			taskLog.Info("handing task back")
+			req := &taskservice.ReleaseTaskRequest{Id: task.Id, LeaseId: task.LeaseId}
+			err := client.call(func(c taskservice.TaskServiceClient) error {
+				_, err := c.ReleaseTask(submitCtx, req)
+				return err
+			})
+			if err != nil {
+				setSpanError(submitSpan, err)
+				taskLog.Error("handing task back", "error", err)
+				workerErrors.WithLabelValues(workerLabel(workerID), "orchestrator").Inc()
+			}
+		} else if err != nil {
+			taskLog.Warn("computing task", "error", err)
+			workerErrors.WithLabelValues(workerLabel(workerID), "compute").Inc()
+			req := &taskservice.ReportErrorRequest{Id: task.Id, LeaseId: task.LeaseId, Error: err.Error()}
+			err := client.report(func(c taskservice.TaskServiceClient) error {
+				_, err := c.ReportError(submitCtx, req)
+				return err
+			})
+			if err != nil {
+				setSpanError(submitSpan, err)
+				reportFailed(taskLog, workerID, "reporting task failure", err)
+			}
+		} else {
+			req := &taskservice.SubmitResultRequest{Id: task.Id, LeaseId: task.LeaseId, Result: result}
+			err := client.report(func(c taskservice.TaskServiceClient) error {
+				_, err := c.SubmitResult(submitCtx, req)
+				return err
+			})
+			if err != nil {
+				setSpanError(submitSpan, err)
+				reportFailed(taskLog, workerID, "posting task result", err)
+			} else {
+				taskLog.Debug("task completed", "result", result)
+				tasksCompleted.WithLabelValues(workerLabel(workerID)).Inc()
+			}
+		}
+		submitSpan.End()
+		idleSince = time.Now()
+		// Nobody counts free workers once the agent is shutting down
+		select {
+		case freed <- struct{}{}:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// runGRPCAgent starts the workers with the "grpc" transport, adding them to workers.
+// Once ctx is done, no more tasks are received and the workers return after the tasks they have.
+func runGRPCAgent(ctx, finishCtx context.Context, workers *sync.WaitGroup) error {
+	client, err := newGRPCClient(OrchestratorGRPCURLs)
+	if err != nil {
+		logger.Error("creating gRPC client", "error", err)
+		return err
+	}
+	tasks := make(chan *taskservice.Task)
+	freed := make(chan struct{}, ComputingPower)
+	for i := 0; i < ComputingPower; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			grpcWorker(ctx, finishCtx, i, client, tasks, freed)
+		}()
+	}
+	go func() {
+		receiveTasks(ctx, client, tasks, freed)
+		close(tasks)
+	}()
+	go func() {
+		workers.Wait()
+		client.close()
+	}()
+	return nil
+}
@@ -0,0 +1,30 @@
+package agent
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger is the logger of the agent, configured by LOG_LEVEL and LOG_FORMAT.
+var logger = newLogger(os.Stderr, LogLevel, LogFormat).With("component", "agent", "agent_id", AgentID)
+
+// newLogger creates a logger writing "text" or "json" records of the given level and above.
+// Unknown values fall back to "info" and "text".
+func newLogger(w io.Writer, level, format string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	if strings.EqualFold(format, "json") {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// taskLogger returns the logger of a worker for lines about a task.
+func taskLogger(workerID int, taskID, expressionID string) *slog.Logger {
+	return logger.With("worker", workerID, "task_id", taskID, "expression_id", expressionID)
+}
@@ -0,0 +1,58 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	tasksCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calculator_agent_tasks_completed_total",
+		Help: "Tasks computed and reported to the orchestrator, by worker.",
+	}, []string{"worker"})
+	tasksRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calculator_agent_tasks_rejected_total",
+		Help: "Results and errors of tasks the orchestrator rejected because the task was no longer the worker's, by worker.",
+	}, []string{"worker"})
+	workerErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "calculator_agent_errors_total",
+		Help: `Errors by worker and kind: "compute" for failed operations, "orchestrator" for failed requests.`,
+	}, []string{"worker", "kind"})
+	workerIdle = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "calculator_agent_idle_seconds",
+		Help: "Time a worker waited for its next task.",
+		// Long polls keep an idle worker waiting for up to a minute
+		Buckets: slices.Concat(prometheus.DefBuckets, []float64{30, 60}),
+	}, []string{"worker"})
+)
+
+// workerLabel is the value of the worker label of the metrics.
+func workerLabel(workerID int) string {
+	return strconv.Itoa(workerID)
+}
+
+// observeIdle records the time since the worker finished its previous task.
+func observeIdle(workerID int, since time.Time) {
+	workerIdle.WithLabelValues(workerLabel(workerID)).Observe(time.Since(since).Seconds())
+}
+
+// serveMetrics serves the metrics of the agent on MetricsPort until ctx is done.
+func serveMetrics(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: ":" + MetricsPort, Handler: mux}
+	stop := context.AfterFunc(ctx, func() { server.Close() })
+	defer stop()
+	logger.Info("serving metrics", "port", MetricsPort)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("serving metrics", "error", err)
+	}
+}
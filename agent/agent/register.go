@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
+)
+
+// newAgentID returns a random id for an agent that has none configured.
+func newAgentID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// register announces the agent to the orchestrator, retrying until it succeeds or ctx is done.
+func register(ctx context.Context, client *orchestratorClient) {
+	hostname, _ := os.Hostname()
+	payload, _ := json.Marshal(map[string]any{
+		"id":         AgentID,
+		"hostname":   hostname,
+		"workers":    ComputingPower,
+		"operations": calculator.Operations(),
+	})
+	for ctx.Err() == nil {
+		resp, err := client.do(ctx, http.MethodPost, "/internal/agents/register", payload)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				logger.Info("registered with the orchestrator")
+				return
+			}
+			err = fmt.Errorf("orchestrator answered %s", resp.Status)
+		}
+		if ctx.Err() == nil {
+			logger.Warn("registering with the orchestrator", "error", err)
+		}
+		sleep(ctx, 1*time.Second)
+	}
+}
+
+// sendHeartbeats periodically tells the orchestrator that the agent is alive.
+// If the orchestrator doesn't know the agent (e.g. it was restarted), the agent registers again.
+// It returns once ctx is done.
+func sendHeartbeats(ctx context.Context, client *orchestratorClient) {
+	payload, _ := json.Marshal(map[string]string{"id": AgentID})
+	ticker := time.NewTicker(time.Duration(HeartbeatIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		resp, err := client.do(ctx, http.MethodPost, "/internal/agents/heartbeat", payload)
+		if err != nil {
+			logger.Warn("sending heartbeat", "error", err)
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			register(ctx, client)
+		}
+	}
+}
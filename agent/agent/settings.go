@@ -3,12 +3,33 @@ package agent
 import (
 	"os"
 	"strconv"
+	"strings"
 )
 
 // Settings holds all the configuration values
 var (
-	OrchestratorPort = getEnv("ORCHESTRATOR_PORT", "8080")
-	ComputingPower   = getEnvInt("COMPUTING_POWER", 2)
+	// OrchestratorURLs are base URLs (scheme, host, port and optional base path) of the orchestrators'
+	// internal listeners, tried in order when one is unavailable. Defaults to the orchestrator on localhost.
+	OrchestratorURLs = getEnvList("ORCHESTRATOR_URL", "http://localhost:"+getEnv("INTERNAL_PORT", "8081"))
+	// Transport selects how the agent talks to the orchestrator: "http" for the /internal endpoints
+	// or "grpc" for TaskService.
+	Transport = getEnv("AGENT_TRANSPORT", "http")
+	// OrchestratorGRPCURLs are the addresses of the orchestrators' gRPC servers, used with the "grpc" transport.
+	OrchestratorGRPCURLs = getEnvList("ORCHESTRATOR_GRPC_URL", "http://localhost:"+getEnv("GRPC_PORT", "9090"))
+	ComputingPower       = getEnvInt("COMPUTING_POWER", 2)
+	PollWaitMs           = getEnvInt("TASK_POLL_WAIT_MS", 30000)
+	// AgentID identifies the agent in the orchestrator; a random one is generated by default.
+	AgentID             = getEnv("AGENT_ID", newAgentID())
+	HeartbeatIntervalMs = getEnvInt("HEARTBEAT_INTERVAL_MS", 5000)
+	// MetricsPort is the port of the Prometheus metrics listener; empty disables it.
+	MetricsPort = getEnv("AGENT_METRICS_PORT", "")
+	LogLevel    = getEnv("LOG_LEVEL", "info")  // "debug", "info", "warn" or "error"
+	LogFormat   = getEnv("LOG_FORMAT", "text") // "text" or "json"
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the collector receiving traces; empty disables exporting them.
+	OTLPEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	ServiceName  = getEnv("OTEL_SERVICE_NAME", "agent")
+	// ShutdownTimeoutMs is how long tasks in progress may take to finish on shutdown before they are handed back.
+	ShutdownTimeoutMs = getEnvInt("SHUTDOWN_TIMEOUT_MS", 5000)
 )
 
 // getEnv retrieves a string environment variable or returns a default value.
@@ -19,6 +40,17 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
+// getEnvList retrieves a comma-separated list environment variable or returns a default value.
+func getEnvList(key, defaultValue string) []string {
+	var result []string
+	for _, item := range strings.Split(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
 // getEnvInt retrieves an integer environment variable or returns a default value.
 func getEnvInt(key string, defaultValue int) int {
 	if value, exists := os.LookupEnv(key); exists {
@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceParentHeader is the W3C Trace Context header carrying the span context from and to the orchestrator.
+const traceParentHeader = "traceparent"
+
+// tracerProvider exports the spans of the agent to OTEL_EXPORTER_OTLP_ENDPOINT.
+var tracerProvider = newTracerProvider(ServiceName, OTLPEndpoint)
+
+// tracer records the agent's part of expression traces.
+var tracer trace.Tracer = tracerProvider.Tracer("github.com/ZolotarevAlexandr/yl_sprint_2_final/agent")
+
+// propagator carries span contexts in the traceparent header.
+var propagator = propagation.TraceContext{}
+
+// newTracerProvider returns a provider exporting spans of the service over OTLP/HTTP to the collector
+// at endpoint, e.g. "http://localhost:4318". Without an endpoint spans are still created, so that
+// trace context reaches the orchestrator, but they are not exported.
+func newTracerProvider(service, endpoint string) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(attribute.String("service.name", service))
+	if endpoint == "" {
+		return sdktrace.NewTracerProvider(sdktrace.WithResource(res))
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("exporting spans", "error", err)
+	}))
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		logger.Error("creating span exporter", "error", err)
+		return sdktrace.NewTracerProvider(sdktrace.WithResource(res))
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+}
+
+// traceParent returns the traceparent value of the span in ctx, or "" if there is none.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(traceParentHeader)
+}
+
+// contextWithTraceParent returns ctx with the span context of a traceparent value as its remote parent.
+func contextWithTraceParent(ctx context.Context, value string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier{traceParentHeader: value})
+}
+
+// setSpanError marks the span as failed with err; a nil err leaves the span as it is.
+func setSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// computeTask simulates the operation time and evaluates the operation under a "compute task" span,
+// a child of the orchestrator's dispatch span in ctx. If ctx is cancelled first, it returns ctx.Err().
+func computeTask(ctx context.Context, workerID int, taskID, operation string, args []float64, operationTime int) (float64, error) {
+	_, span := tracer.Start(ctx, "compute task",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("task_id", taskID),
+			attribute.String("operation", operation),
+			attribute.Int("worker", workerID)))
+	defer span.End()
+	// Simulate long computation time
+	timer := time.NewTimer(time.Duration(operationTime) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		setSpanError(span, ctx.Err())
+		return 0, ctx.Err()
+	}
+	result, err := calculator.EvaluateOperation(operation, args...)
+	setSpanError(span, err)
+	return result, err
+}
+
+// startSubmitSpan starts the span of reporting a task result (or error) to the orchestrator.
+// Calls made with the returned context propagate the span to the orchestrator.
+func startSubmitSpan(ctx context.Context, taskID string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "submit result",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("task_id", taskID)))
+}
@@ -1,8 +1,19 @@
 package main
 
-import "github.com/ZolotarevAlexandr/yl_sprint_2_final/agent/agent"
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/agent/agent"
+)
 
 func main() {
-	go agent.RunAgent()
-	select {}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := agent.RunAgent(ctx)
+	stop()
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,84 @@
+package calculator
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCalculate(t *testing.T) {
+	tests := []struct {
+		expression string
+		want       float64
+	}{
+		{"2+2*2", 6},
+		{"(2+2)*2", 8},
+		{"-3+4", 1},
+		{"2*(-1)", -2},
+		{"--5", 5},
+		{"+5-+2", 3},
+		{"-(2+3)*2", -10},
+		{"2--3", 5},
+		{"2^3^2", 512},
+		{"(2^3)^2", 64},
+		{"2*3^2", 18},
+		{"-2^2", -4},
+		{"2^-1", 0.5},
+		{"max(sqrt(16), 2*3)", 6},
+		{"min(3, -1, 2)", -1},
+		{"abs(-2)+sqrt(4)", 4},
+		{"max(1)", 1},
+		{"cos(0)*sin(0)", 0},
+		{"log(1)", 0},
+		{"-sqrt(9)^2", -9},
+	}
+	for _, tt := range tests {
+		got, err := Calculate(tt.expression)
+		if err != nil {
+			t.Errorf("Calculate(%q) returned error: %v", tt.expression, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Calculate(%q) = %v, want %v", tt.expression, got, tt.want)
+		}
+	}
+}
+
+func TestCalculateErrors(t *testing.T) {
+	for _, expression := range []string{"2+", "(2+2", "2+2)", "1/0", "2 $ 2", "-", "0^-1", "(-8)^0.5",
+		"sqrt(1, 2)", "max()", "max(1,)", "max(,1)", "1, 2", "sqrt 4", "foo(1)", "sqrt(-1)"} {
+		if _, err := Calculate(expression); err == nil {
+			t.Errorf("Calculate(%q) expected error", expression)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		expression string
+		code       string
+		position   int
+		token      string
+	}{
+		{"", ErrCodeEmptyExpression, 0, ""},
+		{"2 $ 2", ErrCodeUnknownSymbol, 2, "$"},
+		{"2+", ErrCodeMissingOperand, 1, "+"},
+		{"2+*3", ErrCodeMissingOperand, 2, "*"},
+		{"2 3", ErrCodeMissingOperator, 2, "3"},
+		{"(2+2", ErrCodeMismatchedParenthesis, 0, "("},
+		{"2+2)", ErrCodeMismatchedParenthesis, 3, ")"},
+		{"1 + sqrt(1, 2)", ErrCodeInvalidFunctionCall, 4, "sqrt"},
+		{"max(1,)", ErrCodeMissingOperand, 6, ")"},
+		{"1, 2", ErrCodeInvalidFunctionCall, 1, ","},
+	}
+	for _, tt := range tests {
+		_, err := Calculate(tt.expression)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Calculate(%q) expected *ParseError, got %v", tt.expression, err)
+			continue
+		}
+		if parseErr.Code != tt.code || parseErr.Position != tt.position || parseErr.Token != tt.token {
+			t.Errorf("Calculate(%q) = %+v, want code %s at %d (%q)", tt.expression, parseErr, tt.code, tt.position, tt.token)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package calculator
+
+import "fmt"
+
+// Error codes of ParseError.
+const (
+	ErrCodeEmptyExpression       = "empty_expression"
+	ErrCodeUnknownSymbol         = "unknown_symbol"
+	ErrCodeMismatchedParenthesis = "mismatched_parenthesis"
+	ErrCodeMissingOperand        = "missing_operand"
+	ErrCodeMissingOperator       = "missing_operator"
+	ErrCodeInvalidFunctionCall   = "invalid_function_call"
+)
+
+// ParseError describes a syntax error in an expression.
+type ParseError struct {
+	Code     string // one of the ErrCode constants
+	Message  string
+	Position int    // byte offset of the offending token in the expression
+	Token    string // text of the offending token, empty at the end of the expression
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Message, e.Position)
+}
+
+// newParseError creates a ParseError pointing at the given token.
+func newParseError(code string, token Token, format string, args ...any) *ParseError {
+	return &ParseError{
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		Position: token.Position,
+		Token:    token.Text,
+	}
+}
@@ -1,6 +1,11 @@
 package calculator
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
 
 func Evaluate(tokens []Token) (float64, error) {
 	stack := make([]float64, 0)
@@ -9,17 +14,18 @@ func Evaluate(tokens []Token) (float64, error) {
 		case token.IsOperand:
 			val, _ := token.GetOperand()
 			stack = append(stack, val)
-		case token.IsOperator:
-			if len(stack) < 2 {
+		case token.IsOperator || token.IsFunction:
+			arity := token.Arity()
+			if len(stack) < arity {
 				return 0.0, errors.New("not enough operands")
 			}
-			num1, num2 := stack[len(stack)-2], stack[len(stack)-1]
-			stack = stack[:len(stack)-2]
+			args := append([]float64(nil), stack[len(stack)-arity:]...)
+			stack = stack[:len(stack)-arity]
 			operator, err := token.getOperator()
 			if err != nil {
 				return 0.0, err
 			}
-			val, err := EvaluateOperation(operator, num1, num2)
+			val, err := EvaluateOperation(operator, args...)
 			if err != nil {
 				return 0.0, err
 			}
@@ -32,7 +38,41 @@ func Evaluate(tokens []Token) (float64, error) {
 	return stack[len(stack)-1], nil
 }
 
-func EvaluateOperation(operator string, num1, num2 float64) (float64, error) {
+// Operations returns the sorted names of all operators and functions EvaluateOperation supports.
+func Operations() []string {
+	operations := []string{"neg", "pos"}
+	for operator := range priorities {
+		operations = append(operations, operator)
+	}
+	for name := range functions {
+		operations = append(operations, name)
+	}
+	sort.Strings(operations)
+	return operations
+}
+
+// EvaluateOperation applies the operator or built-in function to its arguments.
+// Binary operators take two arguments, unary operators ("neg", "pos") take one.
+func EvaluateOperation(operator string, args ...float64) (float64, error) {
+	if function, ok := functions[operator]; ok {
+		if err := function.checkArity(operator, len(args)); err != nil {
+			return 0.0, err
+		}
+		return function.Eval(args)
+	}
+	if IsUnaryOperator(operator) {
+		if len(args) != 1 {
+			return 0.0, fmt.Errorf("operator %s expects 1 operand, got %d", operator, len(args))
+		}
+		if operator == "neg" {
+			return -args[0], nil
+		}
+		return args[0], nil
+	}
+	if len(args) != 2 {
+		return 0.0, fmt.Errorf("operator %s expects 2 operands, got %d", operator, len(args))
+	}
+	num1, num2 := args[0], args[1]
 	switch operator {
 	case "+":
 		return num1 + num2, nil
@@ -45,6 +85,12 @@ func EvaluateOperation(operator string, num1, num2 float64) (float64, error) {
 			return 0.0, errors.New("division by zero")
 		}
 		return num1 / num2, nil
+	case "^":
+		result := math.Pow(num1, num2)
+		if math.IsNaN(result) || math.IsInf(result, 0) {
+			return 0.0, errors.New("invalid power operation")
+		}
+		return result, nil
 	}
 	return 0.0, errors.New("invalid operand")
 }
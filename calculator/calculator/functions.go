@@ -0,0 +1,74 @@
+package calculator
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Function describes a built-in function that can be called as name(arg1, arg2, ...).
+type Function struct {
+	MinArgs int
+	MaxArgs int // -1 means any number of arguments
+	Eval    func(args []float64) (float64, error)
+}
+
+// checkArity returns an error if the function can't be called with n arguments.
+func (f Function) checkArity(name string, n int) error {
+	if n < f.MinArgs || (f.MaxArgs >= 0 && n > f.MaxArgs) {
+		switch {
+		case f.MinArgs == f.MaxArgs:
+			return fmt.Errorf("function %s expects %d argument(s), got %d", name, f.MinArgs, n)
+		case f.MaxArgs < 0:
+			return fmt.Errorf("function %s expects at least %d argument(s), got %d", name, f.MinArgs, n)
+		default:
+			return fmt.Errorf("function %s expects %d to %d arguments, got %d", name, f.MinArgs, f.MaxArgs, n)
+		}
+	}
+	return nil
+}
+
+// functions is the registry of built-in functions.
+var functions = map[string]Function{
+	"sqrt": {MinArgs: 1, MaxArgs: 1, Eval: func(args []float64) (float64, error) {
+		if args[0] < 0 {
+			return 0.0, errors.New("square root of negative number")
+		}
+		return math.Sqrt(args[0]), nil
+	}},
+	"sin": {MinArgs: 1, MaxArgs: 1, Eval: func(args []float64) (float64, error) {
+		return math.Sin(args[0]), nil
+	}},
+	"cos": {MinArgs: 1, MaxArgs: 1, Eval: func(args []float64) (float64, error) {
+		return math.Cos(args[0]), nil
+	}},
+	"log": {MinArgs: 1, MaxArgs: 1, Eval: func(args []float64) (float64, error) {
+		if args[0] <= 0 {
+			return 0.0, errors.New("logarithm of non-positive number")
+		}
+		return math.Log(args[0]), nil
+	}},
+	"abs": {MinArgs: 1, MaxArgs: 1, Eval: func(args []float64) (float64, error) {
+		return math.Abs(args[0]), nil
+	}},
+	"min": {MinArgs: 1, MaxArgs: -1, Eval: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Min(result, arg)
+		}
+		return result, nil
+	}},
+	"max": {MinArgs: 1, MaxArgs: -1, Eval: func(args []float64) (float64, error) {
+		result := args[0]
+		for _, arg := range args[1:] {
+			result = math.Max(result, arg)
+		}
+		return result, nil
+	}},
+}
+
+// IsFunction reports whether name is a built-in function.
+func IsFunction(name string) bool {
+	_, ok := functions[name]
+	return ok
+}
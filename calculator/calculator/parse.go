@@ -1,17 +1,73 @@
 package calculator
 
-import "fmt"
+// validate checks that operands and operators alternate correctly,
+// so that errors point at the exact token instead of surfacing later as "not enough operands".
+func validate(tokens []Token) error {
+	if len(tokens) == 0 {
+		return &ParseError{Code: ErrCodeEmptyExpression, Message: "empty expression"}
+	}
+	expectOperand := true
+	for i, token := range tokens {
+		if expectOperand {
+			switch {
+			case token.IsOperand:
+				expectOperand = false
+			case token.IsUnary || token.IsFunction || token.isOpeningBracket():
+			case token.isClosingBracket() && i >= 2 && tokens[i-1].isOpeningBracket() && tokens[i-2].IsFunction:
+				// Call without arguments, its arity is checked by ShuntingYard
+				expectOperand = false
+			default:
+				return newParseError(ErrCodeMissingOperand, token, "missing operand before %s", token.Text)
+			}
+		} else {
+			switch {
+			case token.IsOperator || token.IsComma:
+				expectOperand = true
+			case token.isClosingBracket():
+			default:
+				return newParseError(ErrCodeMissingOperator, token, "missing operator before %s", token.Text)
+			}
+		}
+	}
+	if expectOperand {
+		last := tokens[len(tokens)-1]
+		return newParseError(ErrCodeMissingOperand, last, "missing operand after %s", last.Text)
+	}
+	return nil
+}
 
+// ShuntingYard converts the tokens to Reverse Polish Notation.
+// Syntax errors are reported as a *ParseError.
 func ShuntingYard(tokens []Token) ([]Token, error) {
+	if err := validate(tokens); err != nil {
+		return nil, err
+	}
+
 	outputStack := make([]Token, 0)
 	operatorsStack := make([]Token, 0)
+	// For every open bracket: whether it starts a function call.
+	bracketIsCall := make([]bool, 0)
+	// For every open function call: number of arguments seen so far.
+	argCounts := make([]int, 0)
 
-	for _, token := range tokens {
+	for i, token := range tokens {
 		switch {
+		case token.IsFunction:
+			if i+1 >= len(tokens) || !tokens[i+1].isOpeningBracket() {
+				return nil, newParseError(ErrCodeInvalidFunctionCall, token, "expected ( after function %s", token.Text)
+			}
+			operatorsStack = append(operatorsStack, token)
+
+		case token.IsUnary:
+			// A prefix operator has no left operand, so nothing is popped for it.
+			operatorsStack = append(operatorsStack, token)
+
 		case token.IsOperator:
 			for len(operatorsStack) > 0 {
 				top := operatorsStack[len(operatorsStack)-1]
-				if top.IsOperator && (token.Priority <= top.Priority) {
+				// Operators of equal priority are popped only for left-associative tokens.
+				if top.IsOperator && (token.Priority < top.Priority ||
+					token.Priority == top.Priority && !token.isRightAssociative()) {
 					outputStack = append(outputStack, top)
 					operatorsStack = operatorsStack[:len(operatorsStack)-1]
 				} else {
@@ -21,8 +77,23 @@ func ShuntingYard(tokens []Token) ([]Token, error) {
 			operatorsStack = append(operatorsStack, token)
 
 		case token.isOpeningBracket():
+			isCall := len(operatorsStack) > 0 && operatorsStack[len(operatorsStack)-1].IsFunction
+			bracketIsCall = append(bracketIsCall, isCall)
+			if isCall {
+				argCounts = append(argCounts, 0)
+			}
 			operatorsStack = append(operatorsStack, token)
 
+		case token.IsComma:
+			if len(bracketIsCall) == 0 || !bracketIsCall[len(bracketIsCall)-1] {
+				return nil, newParseError(ErrCodeInvalidFunctionCall, token, "comma outside of function call")
+			}
+			for !operatorsStack[len(operatorsStack)-1].isOpeningBracket() {
+				outputStack = append(outputStack, operatorsStack[len(operatorsStack)-1])
+				operatorsStack = operatorsStack[:len(operatorsStack)-1]
+			}
+			argCounts[len(argCounts)-1]++
+
 		case token.isClosingBracket():
 			for len(operatorsStack) > 0 && !operatorsStack[len(operatorsStack)-1].isOpeningBracket() {
 				outputStack = append(outputStack, operatorsStack[len(operatorsStack)-1])
@@ -30,9 +101,28 @@ func ShuntingYard(tokens []Token) ([]Token, error) {
 			}
 			// Remove the open parenthesis
 			if len(operatorsStack) == 0 {
-				return nil, fmt.Errorf("mismatched parentheses")
+				return nil, newParseError(ErrCodeMismatchedParenthesis, token, "unexpected )")
+			}
+			operatorsStack = operatorsStack[:len(operatorsStack)-1]
+			isCall := bracketIsCall[len(bracketIsCall)-1]
+			bracketIsCall = bracketIsCall[:len(bracketIsCall)-1]
+			if !isCall {
+				continue
+			}
+			// Close the function call: the function itself lies right below its bracket
+			argCount := argCounts[len(argCounts)-1]
+			argCounts = argCounts[:len(argCounts)-1]
+			if !tokens[i-1].isOpeningBracket() {
+				argCount++
 			}
+			function := operatorsStack[len(operatorsStack)-1]
 			operatorsStack = operatorsStack[:len(operatorsStack)-1]
+			name, _ := function.getOperator()
+			if err := functions[name].checkArity(name, argCount); err != nil {
+				return nil, newParseError(ErrCodeInvalidFunctionCall, function, "%v", err)
+			}
+			function.ArgCount = argCount
+			outputStack = append(outputStack, function)
 
 		default:
 			outputStack = append(outputStack, token)
@@ -43,7 +133,7 @@ func ShuntingYard(tokens []Token) ([]Token, error) {
 	for len(operatorsStack) > 0 {
 		top := operatorsStack[len(operatorsStack)-1]
 		if top.IsBracket {
-			return nil, fmt.Errorf("mismatched parentheses")
+			return nil, newParseError(ErrCodeMismatchedParenthesis, top, "unclosed (")
 		}
 		outputStack = append(outputStack, top)
 		operatorsStack = operatorsStack[:len(operatorsStack)-1]
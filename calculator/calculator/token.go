@@ -2,6 +2,7 @@ package calculator
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"text/scanner"
@@ -9,14 +10,33 @@ import (
 
 type Token struct {
 	IsOperator bool
+	IsUnary    bool // prefix operator with a single operand
+	IsFunction bool
 	IsOperand  bool
 	IsBracket  bool
+	IsComma    bool
 	Priority   int
+	ArgCount   int // number of arguments of a function call, set by ShuntingYard
 	Value      any
+	Text       string // token as written in the expression
+	Position   int    // byte offset of the token in the expression
+}
+
+// Arity returns the number of operands an operator or function token consumes.
+func (t Token) Arity() int {
+	switch {
+	case t.IsFunction:
+		return t.ArgCount
+	case t.IsUnary:
+		return 1
+	case t.IsOperator:
+		return 2
+	}
+	return 0
 }
 
 func (t Token) getOperator() (string, error) {
-	if t.IsOperator == false {
+	if t.IsOperator == false && t.IsFunction == false {
 		return "", errors.New("token is not an operator")
 	}
 	return t.Value.(string), nil
@@ -36,6 +56,15 @@ func (t Token) getBracket() (string, error) {
 	return t.Value.(string), nil
 }
 
+// isRightAssociative reports whether the token is a right-associative operator.
+func (t Token) isRightAssociative() bool {
+	val, err := t.getOperator()
+	if err != nil {
+		return false
+	}
+	return rightAssociative[val]
+}
+
 func (t Token) isOpeningBracket() bool {
 	val, err := t.getBracket()
 	if err != nil {
@@ -57,6 +86,36 @@ var priorities = map[string]int{
 	"-": 1,
 	"*": 2,
 	"/": 2,
+	"^": 4,
+}
+
+// rightAssociative lists binary operators that group from the right, e.g. 2^3^2 = 2^(3^2).
+var rightAssociative = map[string]bool{
+	"^": true,
+}
+
+// unaryOperators maps a sign written in operand position to the name of its unary operator.
+var unaryOperators = map[string]string{
+	"-": "neg",
+	"+": "pos",
+}
+
+// unaryPriority binds unary operators tighter than "*" and "/" but looser than "^", so -2^2 = -(2^2).
+const unaryPriority = 3
+
+// IsUnaryOperator reports whether the operator takes a single operand.
+func IsUnaryOperator(operator string) bool {
+	return operator == "neg" || operator == "pos"
+}
+
+// expectsOperand reports whether the next token must start an operand,
+// i.e. it is at the beginning of the expression, after an operator, "(" or ",".
+func expectsOperand(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	last := tokens[len(tokens)-1]
+	return last.IsOperator || last.IsComma || last.isOpeningBracket()
 }
 
 func strToToken(str string) (Token, error) {
@@ -66,6 +125,12 @@ func strToToken(str string) (Token, error) {
 	if str == "(" || str == ")" {
 		return Token{IsBracket: true, Value: str}, nil
 	}
+	if str == "," {
+		return Token{IsComma: true, Value: str}, nil
+	}
+	if IsFunction(str) {
+		return Token{IsFunction: true, Value: str}, nil
+	}
 	num, err := strconv.ParseFloat(str, 64)
 	if err == nil {
 		return Token{IsOperand: true, Value: num}, nil
@@ -73,12 +138,18 @@ func strToToken(str string) (Token, error) {
 	return Token{}, errors.New("unsupported token value")
 }
 
+// Tokenize splits the expression into tokens.
+// Unknown symbols are reported as a *ParseError.
 func Tokenize(str string) ([]Token, error) {
 	result := make([]Token, 0)
 	var scan scanner.Scanner
 	var token rune
 
 	scan.Init(strings.NewReader(str))
+	// Only numbers and names are scanned as a whole; everything else is a single character.
+	scan.Mode = scanner.ScanIdents | scanner.ScanInts | scanner.ScanFloats
+	// Malformed numbers are reported below as unknown symbols.
+	scan.Error = func(*scanner.Scanner, string) {}
 
 	for token != scanner.EOF {
 		token = scan.Scan()
@@ -88,8 +159,18 @@ func Tokenize(str string) ([]Token, error) {
 		}
 		tok, err := strToToken(val)
 		if err != nil {
-			return []Token{}, err
+			return []Token{}, &ParseError{
+				Code:     ErrCodeUnknownSymbol,
+				Message:  fmt.Sprintf("unknown symbol %s", val),
+				Position: scan.Position.Offset,
+				Token:    val,
+			}
+		}
+		if name, ok := unaryOperators[val]; ok && expectsOperand(result) {
+			tok = Token{IsOperator: true, IsUnary: true, Priority: unaryPriority, Value: name}
 		}
+		tok.Text = val
+		tok.Position = scan.Position.Offset
 		result = append(result, tok)
 	}
 
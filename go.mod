@@ -1,3 +1,3 @@
 module github.com/ZolotarevAlexandr/yl_sprint_2_final
 
-go 1.23
+go 1.24
@@ -1,12 +1,48 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/ZolotarevAlexandr/yl_sprint_2_final/agent/agent"
 	"github.com/ZolotarevAlexandr/yl_sprint_2_final/orchestrator/orchestrator"
 )
 
 func main() {
-	go orchestrator.RunOrchestrator()
-	go agent.RunAgent()
-	select {}
+	// Unless configured otherwise, the embedded agent works with the orchestrator running in this process.
+	if _, ok := os.LookupEnv("ORCHESTRATOR_URL"); !ok {
+		agent.OrchestratorURLs = []string{"http://localhost:" + orchestrator.InternalPort}
+	}
+	if _, ok := os.LookupEnv("ORCHESTRATOR_GRPC_URL"); !ok && orchestrator.GRPCPort != "" {
+		agent.OrchestratorGRPCURLs = []string{"http://localhost:" + orchestrator.GRPCPort}
+	}
+
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	orchestratorCtx, stopOrchestrator := context.WithCancel(context.Background())
+	orchestratorDone := make(chan error, 1)
+	go func() { orchestratorDone <- orchestrator.RunOrchestrator(orchestratorCtx) }()
+	agentCtx, stopAgent := context.WithCancel(context.Background())
+	agentDone := make(chan error, 1)
+	go func() { agentDone <- agent.RunAgent(agentCtx) }()
+
+	// Both return early only if they fail
+	var err error
+	select {
+	case <-signals.Done():
+		// The agent stops first, so that it can still report or hand back its tasks
+		stopAgent()
+		err = <-agentDone
+	case err = <-agentDone:
+	case err = <-orchestratorDone:
+		stopAgent()
+		<-agentDone
+		os.Exit(1)
+	}
+	stopOrchestrator()
+	if orchestratorErr := <-orchestratorDone; orchestratorErr != nil || err != nil {
+		os.Exit(1)
+	}
 }
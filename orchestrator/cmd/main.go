@@ -1,8 +1,19 @@
 package main
 
-import "github.com/ZolotarevAlexandr/yl_sprint_2_final/orchestrator/orchestrator"
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/orchestrator/orchestrator"
+)
 
 func main() {
-	go orchestrator.RunOrchestrator()
-	select {}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	err := orchestrator.RunOrchestrator(ctx)
+	stop()
+	if err != nil {
+		os.Exit(1)
+	}
 }
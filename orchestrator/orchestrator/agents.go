@@ -0,0 +1,121 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// agentsStore holds registered agents by id. Access is guarded by storeMutex.
+var agentsStore = make(map[string]*Agent)
+
+// Agent represents a registered agent.
+type Agent struct {
+	ID           string    `json:"id"`
+	Hostname     string    `json:"hostname"`
+	Workers      int       `json:"workers"`
+	Operations   []string  `json:"operations"`
+	Status       string    `json:"status"` // "alive" or "dead"
+	RegisteredAt time.Time `json:"registered_at"`
+	LastSeen     time.Time `json:"last_seen"`
+	InFlight     int       `json:"in_flight"` // filled in when listing agents
+	Completed    int       `json:"completed"`
+}
+
+// handleRegisterAgent handles POST /internal/agents/register. Registering again with the same id
+// (e.g. after the orchestrator restarted) updates the agent and marks it alive.
+func handleRegisterAgent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID         string   `json:"id"`
+		Hostname   string   `json:"hostname"`
+		Workers    int      `json:"workers"`
+		Operations []string `json:"operations"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	registerAgent(req.ID, req.Hostname, req.Workers, req.Operations)
+	json.NewEncoder(w).Encode(map[string]string{"status": "agent registered"})
+}
+
+// registerAgent adds the agent to the registry or updates it and marks it alive.
+func registerAgent(id, hostname string, workers int, operations []string) {
+	now := time.Now()
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	agent, ok := agentsStore[id]
+	if !ok {
+		agent = &Agent{ID: id, RegisteredAt: now}
+		agentsStore[id] = agent
+	}
+	agent.Hostname = hostname
+	agent.Workers = workers
+	agent.Operations = operations
+	agent.Status = "alive"
+	agent.LastSeen = now
+}
+
+// touchAgent records a heartbeat of the agent. It returns false if the agent is not registered.
+func touchAgent(id string) bool {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	agent, ok := agentsStore[id]
+	if ok {
+		agent.Status = "alive"
+		agent.LastSeen = time.Now()
+	}
+	return ok
+}
+
+// handleHeartbeat handles POST /internal/agents/heartbeat.
+// Unknown agents get 404 and are expected to register again.
+func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	if !touchAgent(req.ID) {
+		http.Error(w, "agent not registered", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// handleListAgents returns all registered agents with the number of tasks they are computing.
+func handleListAgents(w http.ResponseWriter, r *http.Request) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	agents := make([]Agent, 0, len(agentsStore))
+	for _, agent := range agentsStore {
+		a := *agent
+		a.InFlight = len(agentTasks[agent.ID])
+		agents = append(agents, a)
+	}
+	json.NewEncoder(w).Encode(map[string]any{"agents": agents})
+}
+
+// reapDeadAgents marks agents without a heartbeat for AgentTimeoutMs as dead
+// and re-queues the tasks they were computing. Caller must hold storeMutex.
+func reapDeadAgents(now time.Time) {
+	timeout := time.Duration(AgentTimeoutMs) * time.Millisecond
+	for _, agent := range agentsStore {
+		if agent.Status == "alive" && now.Sub(agent.LastSeen) > timeout {
+			agent.Status = "dead"
+			for id := range agentTasks[agent.ID] {
+				requeueTask(tasksStore[id], "agent stopped responding")
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package orchestrator
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// jwtHeader is the encoded header of every token: HMAC-SHA256 signed JWTs.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// jwtKey signs the tokens. Without JWT_SECRET a random key is used, so tokens
+// are only valid until the orchestrator restarts.
+var jwtKey = loadJWTKey()
+
+func loadJWTKey() []byte {
+	if JWTSecret != "" {
+		return []byte(JWTSecret)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// tokenClaims is the payload of a token.
+type tokenClaims struct {
+	Subject  string `json:"sub"` // user id
+	Login    string `json:"login"`
+	IssuedAt int64  `json:"iat"`
+	Expires  int64  `json:"exp"`
+}
+
+var errInvalidToken = errors.New("invalid token")
+
+// issueToken creates a token for the user valid for JWTTTLMs.
+func issueToken(user *User, now time.Time) (string, error) {
+	payload, err := json.Marshal(tokenClaims{
+		Subject:  user.ID,
+		Login:    user.Login,
+		IssuedAt: now.Unix(),
+		Expires:  now.Add(time.Duration(JWTTTLMs) * time.Millisecond).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + signToken(unsigned), nil
+}
+
+// parseToken verifies the signature and expiry of the token and returns its claims.
+func parseToken(token string, now time.Time) (*tokenClaims, error) {
+	header, rest, ok := strings.Cut(token, ".")
+	if !ok || header != jwtHeader {
+		return nil, errInvalidToken
+	}
+	payload, signature, ok := strings.Cut(rest, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(signToken(header+"."+payload))) {
+		return nil, errInvalidToken
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil || claims.Subject == "" {
+		return nil, errInvalidToken
+	}
+	if now.Unix() >= claims.Expires {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+// signToken returns the encoded signature of the header and payload.
+func signToken(unsigned string) string {
+	mac := hmac.New(sha256.New, jwtKey)
+	mac.Write([]byte(unsigned))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// userIDKey is the context key of the id of the authenticated user.
+type userIDKey struct{}
+
+// requestOwner returns the id of the user who made the request, or "" for requests
+// that didn't pass through AuthMiddleware.
+func requestOwner(r *http.Request) string {
+	id, _ := r.Context().Value(userIDKey{}).(string)
+	return id
+}
+
+// AuthMiddleware rejects requests without a valid "Authorization: Bearer <token>" header
+// and passes the id of the user to the handler in the request context.
+// Streaming endpoints also take the token from the access_token query parameter, see requestToken.
+func AuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := requestToken(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		claims, err := parseToken(token, time.Now())
+		if err == nil {
+			storeMutex.Lock()
+			user, ok := usersStore[claims.Login]
+			storeMutex.Unlock()
+			if !ok || user.ID != claims.Subject {
+				// Signed for a user this orchestrator doesn't know, e.g. with in-memory storage after a restart
+				err = errInvalidToken
+			}
+		}
+		if err != nil {
+			logger.InfoContext(r.Context(), "rejected token", "error", err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userIDKey{}, claims.Subject)))
+	})
+}
+
+// requestToken returns the bearer token of the request. Browsers can't set headers on WebSocket
+// and EventSource connections, so GET /api/v1/ws and GET /api/v1/expressions/:id/events also accept
+// it in the access_token query parameter (RFC 6750, section 2.3); other endpoints don't, to keep
+// tokens out of URLs where a header works.
+func requestToken(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return token, true
+	}
+	streaming := r.URL.Path == "/api/v1/ws" ||
+		strings.HasPrefix(r.URL.Path, "/api/v1/expressions/") && strings.HasSuffix(r.URL.Path, "/events")
+	if token := r.URL.Query().Get("access_token"); streaming && r.Method == http.MethodGet && token != "" {
+		return token, true
+	}
+	return "", false
+}
+
+// ownedExpression returns the expression if it belongs to the owner. Expressions of other
+// users are reported as missing, so that their ids can't be probed. Caller must hold storeMutex.
+func ownedExpression(id, owner string) (*Expression, bool) {
+	expr, ok := expressionsStore[id]
+	if !ok || expr.Owner != owner {
+		return nil, false
+	}
+	return expr, true
+}
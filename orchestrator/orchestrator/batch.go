@@ -0,0 +1,137 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
+	"github.com/google/uuid"
+)
+
+// batchItem is the outcome of a single expression of a batch: its id or why it was rejected.
+type batchItem struct {
+	Index int            `json:"index"`
+	ID    string         `json:"id,omitempty"`
+	Error map[string]any `json:"error,omitempty"`
+}
+
+// Batch is the aggregate state of the expressions submitted in one batch.
+type Batch struct {
+	ID          string        `json:"id"`
+	Status      string        `json:"status"` // "pending" while any expression is, then "done" or "error"
+	Total       int           `json:"total"`
+	Pending     int           `json:"pending"`
+	Done        int           `json:"done"`
+	Failed      int           `json:"error"`
+	Cancelled   int           `json:"cancelled"`
+	Expressions []*Expression `json:"expressions"`
+}
+
+// handleCalculateBatch handles POST /api/v1/calculate/batch. Every expression is validated
+// and submitted on its own; the response lists the id or the parse error of each one, in order.
+func handleCalculateBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if refuseWhileShuttingDown(w) {
+		return
+	}
+	var req struct {
+		Expressions []string `json:"expressions"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || len(req.Expressions) == 0 {
+		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	if len(req.Expressions) > MaxBatchSize {
+		http.Error(w, "batch too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	owner := requestOwner(r)
+	batchID := uuid.New().String()
+	items := make([]batchItem, len(req.Expressions))
+	accepted := 0
+	for i, expression := range req.Expressions {
+		items[i].Index = i
+		expr, err := submitExpression(r.Context(), &Expression{Expr: expression, BatchID: batchID, Owner: owner})
+		if err != nil {
+			var parseErr *calculator.ParseError
+			if errors.As(err, &parseErr) {
+				items[i].Error = parseErrorBody(parseErr)
+			} else {
+				items[i].Error = map[string]any{"message": "error processing expression"}
+			}
+			continue
+		}
+		items[i].ID = expr.ID
+		accepted++
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if accepted == 0 {
+		// Nothing was submitted, so there is no batch to query
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]any{"batch_id": batchID, "items": items})
+}
+
+// handleGetBatch handles GET /api/v1/batches/:id.
+func handleGetBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/batches/")
+	storeMutex.Lock()
+	batch := aggregateBatch(id, requestOwner(r))
+	storeMutex.Unlock()
+	if batch == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"batch": batch})
+}
+
+// aggregateBatch collects the expressions of the owner's batch in submission order and counts them by status.
+// It returns nil if no expression belongs to the batch. Caller must hold storeMutex.
+func aggregateBatch(id, owner string) *Batch {
+	batch := &Batch{ID: id, Expressions: []*Expression{}}
+	for _, exprID := range batchIndex[id] {
+		expr, ok := expressionsStore[exprID]
+		if !ok || expr.Owner != owner {
+			continue
+		}
+		e := *expr
+		batch.Expressions = append(batch.Expressions, &e)
+		switch expr.Status {
+		case "pending":
+			batch.Pending++
+		case "done":
+			batch.Done++
+		case "error":
+			batch.Failed++
+		case "cancelled":
+			batch.Cancelled++
+		}
+	}
+	batch.Total = len(batch.Expressions)
+	if batch.Total == 0 {
+		return nil
+	}
+	switch {
+	case batch.Pending > 0:
+		batch.Status = "pending"
+	case batch.Done == batch.Total:
+		batch.Status = "done"
+	default:
+		batch.Status = "error"
+	}
+	return batch
+}
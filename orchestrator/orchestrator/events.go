@@ -0,0 +1,188 @@
+package orchestrator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseKeepAliveInterval is how often an idle event stream sends a comment to keep the connection open.
+const sseKeepAliveInterval = 15 * time.Second
+
+// subscriberBuffer is the number of events a subscriber may lag behind before it is dropped.
+const subscriberBuffer = 64
+
+// expressionEvent is a change of an expression sent to clients following it.
+type expressionEvent struct {
+	Name string // SSE event name: "progress", "done", "error", "cancelled" or "deleted"
+	Data map[string]any
+}
+
+// final reports whether the event ends the stream.
+func (e expressionEvent) final() bool {
+	return e.Name != "progress"
+}
+
+// subscribers maps an expression id to the channels of clients following its events.
+// Access is guarded by storeMutex.
+var subscribers = make(map[string]map[chan expressionEvent]struct{})
+
+// subscribe starts delivering events of the expression to a new channel. Caller must hold storeMutex.
+func subscribe(exprID string) chan expressionEvent {
+	ch := make(chan expressionEvent, subscriberBuffer)
+	if subscribers[exprID] == nil {
+		subscribers[exprID] = make(map[chan expressionEvent]struct{})
+	}
+	subscribers[exprID][ch] = struct{}{}
+	return ch
+}
+
+// unsubscribe stops delivering events to the channel. Caller must hold storeMutex.
+func unsubscribe(exprID string, ch chan expressionEvent) {
+	if _, ok := subscribers[exprID][ch]; !ok {
+		return
+	}
+	delete(subscribers[exprID], ch)
+	if len(subscribers[exprID]) == 0 {
+		delete(subscribers, exprID)
+	}
+	close(ch)
+}
+
+// publishEvent sends the event to everyone following the expression. Subscribers that don't
+// keep up are dropped, their stream ends and they can reconnect. Caller must hold storeMutex.
+func publishEvent(exprID string, event expressionEvent) {
+	for ch := range subscribers[exprID] {
+		select {
+		case ch <- event:
+			if event.final() {
+				unsubscribe(exprID, ch)
+			}
+		default:
+			unsubscribe(exprID, ch)
+		}
+	}
+}
+
+// publishTaskDone sends a progress event for a completed task. Caller must hold storeMutex.
+func publishTaskDone(task *Task) {
+	if len(subscribers[task.ExpressionID]) == 0 {
+		return
+	}
+	count := taskCounts[task.ExpressionID]
+	publishEvent(task.ExpressionID, expressionEvent{Name: "progress", Data: map[string]any{
+		"task_id":         task.ID,
+		"operation":       task.Operator,
+		"result":          task.Result,
+		"completed_tasks": count.completed,
+		"total_tasks":     count.total,
+	}})
+}
+
+// publishExpressionEnd sends the final event of a finished, failed or cancelled expression.
+// Caller must hold storeMutex.
+func publishExpressionEnd(expr *Expression) {
+	if len(subscribers[expr.ID]) == 0 {
+		return
+	}
+	publishEvent(expr.ID, finalEvent(expr))
+}
+
+// expressionEnded records when the expression finished, failed or was cancelled, saves it and
+// notifies everyone interested: clients following its events and its callback URL.
+// Caller must hold storeMutex.
+func expressionEnded(expr *Expression) {
+	now := time.Now()
+	expr.CompletedAt = &now
+	logger.Info("expression ended", "expression_id", expr.ID, "status", expr.Status)
+	scheduleWebhook(expr)
+	saveExpression(expr)
+	publishExpressionEnd(expr)
+}
+
+// finalEvent describes the final state of the expression.
+func finalEvent(expr *Expression) expressionEvent {
+	e := *expr
+	return expressionEvent{Name: expr.Status, Data: map[string]any{"expression": &e}}
+}
+
+// followExpression delivers events of the expression to the returned channel until its final event.
+// For an expression that has already ended, the channel only holds the final event.
+// It returns false if the expression doesn't exist. Caller must hold storeMutex.
+func followExpression(exprID, owner string) (chan expressionEvent, bool) {
+	expr, ok := ownedExpression(exprID, owner)
+	if !ok {
+		return nil, false
+	}
+	if expr.Status == "pending" {
+		return subscribe(exprID), true
+	}
+	ch := make(chan expressionEvent, 1)
+	ch <- finalEvent(expr)
+	close(ch)
+	return ch, true
+}
+
+// handleExpressionEvents handles GET /api/v1/expressions/:id/events. It streams Server-Sent Events:
+// a "progress" event for every completed task and a final "done", "error" or "cancelled" event,
+// after which the stream ends.
+func handleExpressionEvents(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/events")
+	storeMutex.Lock()
+	events, ok := followExpression(id, requestOwner(r))
+	storeMutex.Unlock()
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	defer func() {
+		storeMutex.Lock()
+		unsubscribe(id, events)
+		storeMutex.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				// Dropped for lagging behind, or the expression had already ended
+				return
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+			rc.Flush()
+			if event.final() {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			rc.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeEvent writes the event in the SSE format.
+func writeEvent(w http.ResponseWriter, event expressionEvent) error {
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data)
+	return err
+}
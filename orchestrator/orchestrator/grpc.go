@@ -0,0 +1,193 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/taskservice/taskservice"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// taskServer serves TaskService, the gRPC counterpart of the /internal endpoints.
+type taskServer struct {
+	taskservice.UnimplementedTaskServiceServer
+	// shutdown is cancelled when the orchestrator shuts down, to end long polls and streams.
+	shutdown context.Context
+}
+
+// newGRPCServer creates a server for TaskService. Calls are ended early once shutdown is cancelled.
+func newGRPCServer(shutdown context.Context) *grpc.Server {
+	server := grpc.NewServer()
+	taskservice.RegisterTaskServiceServer(server, &taskServer{shutdown: shutdown})
+	return server
+}
+
+// callContext returns the context of a call that is also cancelled when the orchestrator shuts down.
+func (s *taskServer) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.shutdown, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// incomingTraceParent returns the traceparent metadata of the call.
+func incomingTraceParent(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(traceParentHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (s *taskServer) Register(ctx context.Context, req *taskservice.RegisterRequest) (*taskservice.StatusResponse, error) {
+	if req.AgentId == "" {
+		return nil, status.Error(codes.InvalidArgument, "agent id is required")
+	}
+	registerAgent(req.AgentId, req.Hostname, int(req.Workers), req.Operations)
+	return &taskservice.StatusResponse{Status: "agent registered"}, nil
+}
+
+func (s *taskServer) FetchTask(ctx context.Context, req *taskservice.FetchTaskRequest) (*taskservice.FetchTaskResponse, error) {
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	wait := min(time.Duration(req.WaitMs), time.Duration(MaxTaskWaitMs)) * time.Millisecond
+	a := waitForTask(ctx, req.AgentId, max(wait, 0))
+	if a == nil {
+		return nil, status.Error(codes.NotFound, "no task")
+	}
+	return &taskservice.FetchTaskResponse{Task: a.message()}, nil
+}
+
+func (s *taskServer) SubmitResult(ctx context.Context, req *taskservice.SubmitResultRequest) (*taskservice.StatusResponse, error) {
+	span := startResultSpan(ctx, incomingTraceParent(ctx), req.Id)
+	defer span.End()
+	result, err := submitResult(req.Id, req.LeaseId, req.Result, "")
+	if err != nil {
+		setSpanError(span, err)
+		return nil, taskStatusError(err)
+	}
+	return &taskservice.StatusResponse{Status: result}, nil
+}
+
+func (s *taskServer) ReportError(ctx context.Context, req *taskservice.ReportErrorRequest) (*taskservice.StatusResponse, error) {
+	if req.Error == "" {
+		return nil, status.Error(codes.InvalidArgument, "error is required")
+	}
+	span := startResultSpan(ctx, incomingTraceParent(ctx), req.Id)
+	defer span.End()
+	result, err := submitResult(req.Id, req.LeaseId, 0, req.Error)
+	if err != nil {
+		setSpanError(span, err)
+		return nil, taskStatusError(err)
+	}
+	return &taskservice.StatusResponse{Status: result}, nil
+}
+
+func (s *taskServer) ReleaseTask(ctx context.Context, req *taskservice.ReleaseTaskRequest) (*taskservice.StatusResponse, error) {
+	result, err := releaseTask(req.Id, req.LeaseId)
+	if err != nil {
+		return nil, taskStatusError(err)
+	}
+	return &taskservice.StatusResponse{Status: result}, nil
+}
+
+// taskStatusError maps an error of submitResult to a gRPC status, like taskErrorStatus does for HTTP.
+func taskStatusError(err error) error {
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, errTaskCancelled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, errStaleLease):
+		return status.Error(codes.Aborted, err.Error())
+	default:
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+}
+
+// Heartbeat serves the Heartbeat stream. Every message from the agent counts as a heartbeat
+// and adds its free workers to the number of tasks the orchestrator may push to the agent.
+// Tasks are pushed as soon as they become ready, so the agent doesn't need to poll.
+func (s *taskServer) Heartbeat(stream taskservice.TaskService_HeartbeatServer) error {
+	ctx, cancel := s.callContext(stream.Context())
+	defer cancel()
+
+	updates := make(chan *taskservice.HeartbeatRequest)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			select {
+			case updates <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	agentID := ""
+	free := 0
+	for {
+		var pushed []*assignment
+		storeMutex.Lock()
+		for ; free > 0; free-- {
+			task := takeTask(time.Now(), agentID)
+			if task == nil {
+				break
+			}
+			pushed = append(pushed, newAssignment(task))
+		}
+		ready := taskReady
+		storeMutex.Unlock()
+
+		for _, a := range pushed {
+			// Tasks that can't be delivered are re-queued once their lease expires
+			if err := stream.Send(&taskservice.HeartbeatResponse{Task: a.message()}); err != nil {
+				logger.WarnContext(ctx, "pushing task to agent", "task_id", a.ID, "agent_id", agentID, "error", err)
+				return err
+			}
+		}
+
+		select {
+		case req := <-updates:
+			if !touchAgent(req.AgentId) {
+				return status.Error(codes.NotFound, "agent not registered")
+			}
+			agentID = req.AgentId
+			free += int(req.FreeWorkers)
+		case <-ready:
+		case err := <-recvErr:
+			// The agent closing its side ends the call normally
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+}
+
+// message converts the assignment to its protobuf form.
+func (a *assignment) message() *taskservice.Task {
+	return &taskservice.Task{
+		Id:            a.ID,
+		LeaseId:       a.LeaseID,
+		Operation:     a.Operation,
+		Args:          a.Args,
+		OperationTime: int64(a.OperationTime),
+		ExpressionId:  a.ExpressionID,
+		TraceParent:   a.traceParent,
+	}
+}
@@ -0,0 +1,143 @@
+package orchestrator
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/taskservice/taskservice"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// startGRPCServer serves TaskService on a local port and returns a client for it.
+func startGRPCServer(t *testing.T) taskservice.TaskServiceClient {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := newGRPCServer(context.Background())
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return taskservice.NewTaskServiceClient(conn)
+}
+
+func TestGRPCUnaryCalls(t *testing.T) {
+	resetScheduler()
+	agentsStore = make(map[string]*Agent)
+	client := startGRPCServer(t)
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &taskservice.RegisterRequest{AgentId: "agent1", Workers: 1})
+	if err != nil {
+		t.Fatalf("register failed: %v", err)
+	}
+
+	_, err = client.FetchTask(ctx, &taskservice.FetchTaskRequest{AgentId: "agent1"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("expected NotFound without tasks, got %v", err)
+	}
+
+	expr, err := BuildExpressionTasks("2+3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	fetched, err := client.FetchTask(ctx, &taskservice.FetchTaskRequest{AgentId: "agent1", WaitMs: 1000})
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	task := fetched.Task
+	if task == nil || task.Operation != "+" || len(task.Args) != 2 || task.Args[0] != 2 || task.Args[1] != 3 {
+		t.Fatalf("unexpected task %+v", task)
+	}
+
+	_, err = client.SubmitResult(ctx, &taskservice.SubmitResultRequest{Id: task.Id, LeaseId: "stale", Result: 5})
+	if code := status.Code(err); code != codes.Aborted {
+		t.Fatalf("expected Aborted for a stale lease, got %v", err)
+	}
+	_, err = client.SubmitResult(ctx, &taskservice.SubmitResultRequest{Id: task.Id, LeaseId: task.LeaseId, Result: 5})
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+
+	cancelled, err := BuildExpressionTasks("2*3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	fetched, err = client.FetchTask(ctx, &taskservice.FetchTaskRequest{AgentId: "agent1", WaitMs: 1000})
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	storeMutex.Lock()
+	cancelTasks(cancelled.ID)
+	storeMutex.Unlock()
+	_, err = client.SubmitResult(ctx, &taskservice.SubmitResultRequest{Id: fetched.Task.Id, LeaseId: fetched.Task.LeaseId, Result: 6})
+	if code := status.Code(err); code != codes.Canceled {
+		t.Fatalf("expected Cancelled for a cancelled task, got %v", err)
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if expr.Status != "done" || *expr.Result != 5 {
+		t.Errorf("expected expression to be done with 5, got %+v", expr)
+	}
+}
+
+func TestGRPCHeartbeatPushesTasks(t *testing.T) {
+	resetScheduler()
+	agentsStore = make(map[string]*Agent)
+	client := startGRPCServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	registerAgent("agent1", "host", 1, nil)
+	stream, err := client.Heartbeat(ctx)
+	if err != nil {
+		t.Fatalf("failed to open stream: %v", err)
+	}
+	defer stream.CloseSend()
+	if err := stream.Send(&taskservice.HeartbeatRequest{AgentId: "agent1", FreeWorkers: 1}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	// The task is pushed once it becomes ready, without polling
+	if _, err := BuildExpressionTasks("(1+2)*3"); err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("receive failed: %v", err)
+	}
+	if resp.Task == nil || resp.Task.Operation != "+" {
+		t.Fatalf("expected the addition to be pushed, got %+v", resp.Task)
+	}
+
+	// The agent has no free workers left until it reports the result
+	storeMutex.Lock()
+	running := tasksStore[resp.Task.Id].Status
+	storeMutex.Unlock()
+	if running != "running" {
+		t.Fatalf("expected pushed task to be running, got %q", running)
+	}
+	if _, err := submitResult(resp.Task.Id, resp.Task.LeaseId, 3, ""); err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	if err := stream.Send(&taskservice.HeartbeatRequest{AgentId: "agent1", FreeWorkers: 1}); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	resp, err = stream.Recv()
+	if err != nil {
+		t.Fatalf("receive failed: %v", err)
+	}
+	if resp.Task == nil || resp.Task.Operation != "*" || resp.Task.Args[0] != 3 {
+		t.Fatalf("expected the multiplication to be pushed, got %+v", resp.Task)
+	}
+}
@@ -2,8 +2,12 @@ package orchestrator
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
 )
 
 // handlePing handles GET /api/v1/ping healthcheck endpoint.
@@ -14,15 +18,64 @@ func handlePing(w http.ResponseWriter, r *http.Request) {
 
 // handleCalculate processes POST /api/v1/calculate to add a new expression.
 func handleCalculate(w http.ResponseWriter, r *http.Request) {
+	if refuseWhileShuttingDown(w) {
+		return
+	}
 	var req struct {
-		Expression string `json:"expression"`
+		Expression  string `json:"expression"`
+		CallbackURL string `json:"callback_url"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Expression == "" {
 		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
 		return
 	}
-	expr, err := BuildExpressionTasks(req.Expression)
+	if req.CallbackURL != "" && WebhookSecret == "" {
+		http.Error(w, "callback_url is not supported, WEBHOOK_SECRET is not set", http.StatusUnprocessableEntity)
+		return
+	}
+	if req.CallbackURL != "" && !validCallbackURL(req.CallbackURL) {
+		http.Error(w, "invalid callback_url", http.StatusUnprocessableEntity)
+		return
+	}
+	owner := requestOwner(r)
+	key := r.Header.Get("Idempotency-Key")
+	if len(key) > maxIdempotencyKeyLength {
+		http.Error(w, "idempotency key too long", http.StatusUnprocessableEntity)
+		return
+	}
+	if key != "" {
+		// Keys of different users never collide
+		key = owner + ":" + key
+		canonical, _ := json.Marshal(req)
+		id, err := reserveIdempotencyKey(key, canonical, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		if id != "" {
+			// A retry of a request that has already been handled
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(map[string]string{"id": id})
+			return
+		}
+	}
+	expr, err := submitExpression(r.Context(), &Expression{Expr: req.Expression, CallbackURL: req.CallbackURL, Owner: owner})
+	if key != "" {
+		if err != nil {
+			releaseIdempotencyKey(key)
+		} else {
+			finishIdempotencyKey(key, expr.ID)
+		}
+	}
 	if err != nil {
+		var parseErr *calculator.ParseError
+		if errors.As(err, &parseErr) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			json.NewEncoder(w).Encode(parseErrorBody(parseErr))
+			return
+		}
 		http.Error(w, "error processing expression", http.StatusUnprocessableEntity)
 		return
 	}
@@ -30,6 +83,15 @@ func handleCalculate(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"id": expr.ID})
 }
 
+// parseErrorBody converts a parse error into the JSON body returned to clients.
+func parseErrorBody(err *calculator.ParseError) map[string]any {
+	return map[string]any{
+		"code":     err.Code,
+		"message":  err.Message,
+		"position": err.Position,
+	}
+}
+
 // internalTaskHandler handles agent requests: GET for retrieving a task and POST for submitting the result.
 func internalTaskHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
@@ -39,22 +101,48 @@ func internalTaskHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// handleListExpressions returns a list of all expressions.
+// handleListExpressions returns a page of the caller's expressions in creation order, optionally filtered by status.
+// The next_cursor of the response, if present, fetches the following page.
 func handleListExpressions(w http.ResponseWriter, r *http.Request) {
+	query, err := parseListQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
 	storeMutex.Lock()
 	defer storeMutex.Unlock()
-	var exprList []Expression
-	for _, expr := range expressionsStore {
-		exprList = append(exprList, *expr)
+	exprList, next := listExpressions(requestOwner(r), query.statuses, query.limit, query.cursor, query.desc)
+	resp := map[string]any{"expressions": exprList}
+	if next != nil {
+		resp["next_cursor"] = encodeCursor(*next)
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// expressionHandler handles requests to a single expression:
+// GET returns it, DELETE removes it and POST .../cancel cancels it.
+func expressionHandler(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/events"):
+		handleExpressionEvents(w, r)
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/webhooks"):
+		handleExpressionWebhooks(w, r)
+	case r.Method == http.MethodGet:
+		handleGetExpression(w, r)
+	case r.Method == http.MethodDelete:
+		handleDeleteExpression(w, r)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/cancel"):
+		handleCancelExpression(w, r)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 	}
-	json.NewEncoder(w).Encode(map[string]any{"expressions": exprList})
 }
 
-// handleGetExpression returns a specific expression by its id.
+// handleGetExpression returns a specific expression of the caller by its id.
 func handleGetExpression(w http.ResponseWriter, r *http.Request) {
 	id := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/")
 	storeMutex.Lock()
-	expr, ok := expressionsStore[id]
+	expr, ok := ownedExpression(id, requestOwner(r))
 	storeMutex.Unlock()
 	if !ok {
 		http.Error(w, "not found", http.StatusNotFound)
@@ -63,60 +151,126 @@ func handleGetExpression(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]any{"expression": expr})
 }
 
-// handleGetTask returns a task to the agent for computation.
-func handleGetTask(w http.ResponseWriter, r *http.Request) {
+// handleDeleteExpression removes an expression together with all of its tasks.
+func handleDeleteExpression(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/")
 	storeMutex.Lock()
 	defer storeMutex.Unlock()
-	for _, task := range tasksStore {
-		if task.Status == "pending" && updateTaskDependencies(task) {
-			task.Status = "running"
-			resp := map[string]any{
-				"task": map[string]any{
-					"id":             task.ID,
-					"arg1":           *task.Arg1,
-					"arg2":           *task.Arg2,
-					"operation":      task.Operator,
-					"operation_time": task.OperationTime,
-				},
-			}
-			json.NewEncoder(w).Encode(resp)
+	if _, ok := ownedExpression(id, requestOwner(r)); !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	deleteExpression(id)
+	json.NewEncoder(w).Encode(map[string]string{"status": "expression deleted"})
+}
+
+// handleCancelExpression stops a pending expression: its remaining tasks are no longer
+// handed out and late results from agents are rejected.
+func handleCancelExpression(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/cancel")
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	expr, ok := ownedExpression(id, requestOwner(r))
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if expr.Status != "pending" {
+		http.Error(w, "expression is not pending", http.StatusConflict)
+		return
+	}
+	setExpressionStatus(expr, "cancelled")
+	cancelTasks(id)
+	expressionEnded(expr)
+	json.NewEncoder(w).Encode(map[string]string{"status": "expression cancelled"})
+}
+
+// handleGetTask returns a task to the agent for computation.
+// With the wait query parameter (e.g. ?wait=30s) the request blocks until a task
+// becomes ready or the wait time elapses, instead of answering "no task" right away.
+// Registered agents pass their id in the agent_id query parameter.
+func handleGetTask(w http.ResponseWriter, r *http.Request) {
+	agentID := r.URL.Query().Get("agent_id")
+	var wait time.Duration
+	if value := r.URL.Query().Get("wait"); value != "" {
+		var err error
+		wait, err = time.ParseDuration(value)
+		if err != nil || wait < 0 {
+			http.Error(w, "invalid wait", http.StatusUnprocessableEntity)
 			return
 		}
+		wait = min(wait, time.Duration(MaxTaskWaitMs)*time.Millisecond)
+	}
+	task := waitForTask(r.Context(), agentID, wait)
+	if task == nil {
+		http.Error(w, "no task", http.StatusNotFound)
+		return
+	}
+	if task.traceParent != "" {
+		w.Header().Set(traceParentHeader, task.traceParent)
 	}
-	http.Error(w, "no task", http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]any{"task": task})
 }
 
 // handlePostTask accepts the result from the agent and updates the task status.
+// The result is only accepted from the current lease holder.
+// If the agent reports an error instead of a result, the whole expression fails.
 func handlePostTask(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ID     string  `json:"id"`
-		Result float64 `json:"result"`
+		ID      string  `json:"id"`
+		LeaseID string  `json:"lease_id"`
+		Result  float64 `json:"result"`
+		Error   string  `json:"error"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
 		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
 		return
 	}
-	storeMutex.Lock()
-	task, ok := tasksStore[req.ID]
-	if !ok {
-		storeMutex.Unlock()
-		http.Error(w, "task not found", http.StatusNotFound)
+	span := startResultSpan(r.Context(), r.Header.Get(traceParentHeader), req.ID)
+	defer span.End()
+	status, err := submitResult(req.ID, req.LeaseID, req.Result, req.Error)
+	if err != nil {
+		setSpanError(span, err)
+		http.Error(w, err.Error(), taskErrorStatus(err))
 		return
 	}
-	if task.Status != "running" {
-		storeMutex.Unlock()
-		http.Error(w, "task not in running state", http.StatusUnprocessableEntity)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// handleReleaseTask handles POST /internal/task/release, by which the agent holding
+// the task's lease hands it back to be computed by another agent.
+func handleReleaseTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	task.Status = "done"
-	task.Result = &req.Result
-	// If this is the root task, update the expression status
-	expr, exists := expressionsStore[task.ExpressionID]
-	if exists && expr.RootTaskID == task.ID {
-		expr.Status = "done"
-		expr.Result = &req.Result
+	var req struct {
+		ID      string `json:"id"`
+		LeaseID string `json:"lease_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	status, err := releaseTask(req.ID, req.LeaseID)
+	if err != nil {
+		http.Error(w, err.Error(), taskErrorStatus(err))
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
+
+// taskErrorStatus maps errors of submitResult and releaseTask to HTTP status codes.
+func taskErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errTaskNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errTaskCancelled):
+		return http.StatusGone
+	case errors.Is(err, errStaleLease):
+		return http.StatusConflict
+	default:
+		return http.StatusUnprocessableEntity
 	}
-	storeMutex.Unlock()
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "result recorded"})
 }
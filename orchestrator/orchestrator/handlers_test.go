@@ -1,11 +1,26 @@
 package orchestrator
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func float64Ptr(f float64) *float64 {
@@ -53,6 +68,7 @@ func TestHandleListExpressions(t *testing.T) {
 		Status: "done",
 		Result: float64Ptr(4),
 	}
+	rebuildExpressionIndex()
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil)
 	w := httptest.NewRecorder()
@@ -76,6 +92,75 @@ func TestHandleListExpressions(t *testing.T) {
 	}
 }
 
+func TestHandleListExpressionsPagination(t *testing.T) {
+	resetScheduler()
+	var ids []string
+	for _, expression := range []string{"1", "2+2", "3", "4*4", "5"} {
+		expr, err := BuildExpressionTasks(expression)
+		if err != nil {
+			t.Fatalf("failed to build expression: %v", err)
+		}
+		ids = append(ids, expr.ID)
+	}
+
+	list := func(query string) ([]string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions?"+query, nil)
+		w := httptest.NewRecorder()
+		handleListExpressions(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var resp struct {
+			Expressions []Expression `json:"expressions"`
+			NextCursor  string       `json:"next_cursor"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		var got []string
+		for _, expr := range resp.Expressions {
+			if expr.CreatedAt.IsZero() {
+				t.Errorf("expected created_at on expression %s", expr.ID)
+			}
+			got = append(got, expr.ID)
+		}
+		return got, resp.NextCursor
+	}
+
+	// Walk all pages in creation order
+	var got []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		page, next := list("limit=2&cursor=" + cursor)
+		got = append(got, page...)
+		if next == "" {
+			if pages != 2 {
+				t.Errorf("expected 3 pages, got %d", pages+1)
+			}
+			break
+		}
+		cursor = next
+	}
+	if !reflect.DeepEqual(got, ids) {
+		t.Errorf("expected %v, got %v", ids, got)
+	}
+
+	// Literals are done right away, the rest are pending
+	pending, next := list("status=pending&order=desc")
+	if want := []string{ids[3], ids[1]}; !reflect.DeepEqual(pending, want) || next != "" {
+		t.Errorf("expected %v without next cursor, got %v, %q", want, pending, next)
+	}
+
+	for _, query := range []string{"limit=0", "limit=abc", "status=unknown", "cursor=!!", "order=sideways"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions?"+query, nil)
+		w := httptest.NewRecorder()
+		handleListExpressions(w, req)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status %d, got %d", query, http.StatusUnprocessableEntity, w.Code)
+		}
+	}
+}
+
 func TestHandleGetExpression(t *testing.T) {
 	expressionsStore = make(map[string]*Expression)
 	expressionsStore["test123"] = &Expression{
@@ -109,3 +194,1041 @@ func TestHandleGetExpression(t *testing.T) {
 		t.Errorf("expected id 'test123', got %s", expr.ID)
 	}
 }
+
+func TestHandlePostTaskError(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expressionsStore["expr1"] = &Expression{ID: "expr1", Expr: "1/0+(2+2)", Status: "pending", RootTaskID: "root"}
+	tasksStore["div"] = &Task{ID: "div", ExpressionID: "expr1", Operator: "/", Status: "running", LeaseID: "lease1"}
+	tasksStore["sum"] = &Task{ID: "sum", ExpressionID: "expr1", Operator: "+", Status: "pending"}
+	tasksStore["root"] = &Task{ID: "root", ExpressionID: "expr1", Operator: "+", DepTasks: []string{"div", "sum"}, Args: make([]*float64, 2), Status: "pending"}
+	rebuildScheduler()
+
+	reqBody := `{"id": "div", "lease_id": "lease1", "error": "division by zero"}`
+	req := httptest.NewRequest(http.MethodPost, "/internal/task", strings.NewReader(reqBody))
+	w := httptest.NewRecorder()
+
+	handlePostTask(w, req)
+	res := w.Result()
+
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	expr := expressionsStore["expr1"]
+	if expr.Status != "error" || expr.Error != "division by zero" {
+		t.Errorf("expected expression to fail with reason, got status %q error %q", expr.Status, expr.Error)
+	}
+	if tasksStore["div"].Status != "error" {
+		t.Errorf("expected failed task status 'error', got %q", tasksStore["div"].Status)
+	}
+	for _, id := range []string{"sum", "root"} {
+		if tasksStore[id].Status != "cancelled" {
+			t.Errorf("expected task %s to be cancelled, got %q", id, tasksStore[id].Status)
+		}
+	}
+}
+
+func TestHandlePostTaskStaleLease(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	tasksStore["task1"] = &Task{ID: "task1", Operator: "+", Status: "running", LeaseID: "current"}
+
+	reqBody := `{"id": "task1", "lease_id": "stale", "result": 4}`
+	req := httptest.NewRequest(http.MethodPost, "/internal/task", strings.NewReader(reqBody))
+	w := httptest.NewRecorder()
+
+	handlePostTask(w, req)
+	res := w.Result()
+
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("expected status %d, got %d", http.StatusConflict, res.StatusCode)
+	}
+	if tasksStore["task1"].Result != nil {
+		t.Error("expected result from stale lease holder to be ignored")
+	}
+}
+
+func TestHandleReleaseTask(t *testing.T) {
+	resetScheduler()
+	expr, err := BuildExpressionTasks("2+2")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	storeMutex.Lock()
+	task := takeTask(time.Now(), "agent1")
+	storeMutex.Unlock()
+
+	release := func(body string) int {
+		w := httptest.NewRecorder()
+		handleReleaseTask(w, httptest.NewRequest(http.MethodPost, "/internal/task/release", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := release(fmt.Sprintf(`{"id": %q, "lease_id": "stale"}`, task.ID)); code != http.StatusConflict {
+		t.Errorf("expected a stale lease to be rejected with %d, got %d", http.StatusConflict, code)
+	}
+	if code := release(fmt.Sprintf(`{"id": %q, "lease_id": %q}`, task.ID, task.LeaseID)); code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, code)
+	}
+	if task.Status != "pending" || task.LeaseID != "" || task.Attempts != 0 {
+		t.Errorf("expected the task to be pending again without a counted attempt, got %+v", task)
+	}
+
+	// The task is handed out again, and its result completes the expression
+	storeMutex.Lock()
+	again := takeTask(time.Now(), "agent2")
+	storeMutex.Unlock()
+	if again != task {
+		t.Fatalf("expected the released task to be handed out again, got %+v", again)
+	}
+	if _, err := submitResult(task.ID, task.LeaseID, 4, ""); err != nil || expr.Status != "done" {
+		t.Errorf("expected the expression to be done, got %q (%v)", expr.Status, err)
+	}
+}
+
+func TestShuttingDown(t *testing.T) {
+	resetScheduler()
+	if _, err := BuildExpressionTasks("2+2"); err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	shuttingDown.Store(true)
+	defer shuttingDown.Store(false)
+
+	w := httptest.NewRecorder()
+	handleCalculate(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+1"}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new expressions to be refused with %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleCalculateBatch(w, httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(`{"expressions": ["1+1"]}`)))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected new batches to be refused with %d, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleGetTask(w, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected no task to be handed out, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestReapExpiredLeases(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expressionsStore["expr1"] = &Expression{ID: "expr1", Status: "pending"}
+	now := time.Now()
+	tasksStore["expired"] = &Task{ID: "expired", ExpressionID: "expr1", Status: "running", LeaseID: "l1", Attempts: 1, LeaseExpires: now.Add(-time.Second)}
+	tasksStore["active"] = &Task{ID: "active", ExpressionID: "expr1", Status: "running", LeaseID: "l2", Attempts: 1, LeaseExpires: now.Add(time.Minute)}
+	rebuildScheduler()
+
+	reapExpiredLeases(now)
+
+	if task := tasksStore["expired"]; task.Status != "pending" || task.LeaseID != "" {
+		t.Errorf("expected expired task to be re-queued, got status %q lease %q", task.Status, task.LeaseID)
+	}
+	if task := tasksStore["active"]; task.Status != "running" {
+		t.Errorf("expected active task to stay running, got %q", task.Status)
+	}
+
+	task := tasksStore["expired"]
+	setTaskStatus(task, "running")
+	task.LeaseID, task.LeaseExpires, task.Attempts = "l3", now.Add(-time.Second), MaxTaskAttempts
+	trackLease(task)
+	reapExpiredLeases(now)
+
+	if task := tasksStore["expired"]; task.Status != "error" {
+		t.Errorf("expected task out of attempts to fail, got %q", task.Status)
+	}
+	if expr := expressionsStore["expr1"]; expr.Status != "error" {
+		t.Errorf("expected expression to fail, got %q", expr.Status)
+	}
+}
+
+func TestHandleGetTaskUnary(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expr, err := BuildExpressionTasks("-(2+3)")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	root := tasksStore[expr.RootTaskID]
+	if root.Operator != "neg" || len(root.DepTasks) != 1 || root.DepTasks[0] == "" {
+		t.Fatalf("expected unary negate root task, got %+v", root)
+	}
+	storeMutex.Lock()
+	dep := takeTask(time.Now(), "")
+	if dep == nil || dep.ID != root.DepTasks[0] {
+		t.Fatalf("expected dependency task to be handed out first, got %+v", dep)
+	}
+	completeTask(dep, 5)
+	storeMutex.Unlock()
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/task", nil)
+	w := httptest.NewRecorder()
+
+	handleGetTask(w, req)
+	res := w.Result()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	var resp struct {
+		Task struct {
+			Operation string    `json:"operation"`
+			Args      []float64 `json:"args"`
+		} `json:"task"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Task.Operation != "neg" || len(resp.Task.Args) != 1 || resp.Task.Args[0] != 5 {
+		t.Errorf("expected negate task with single argument 5, got %+v", resp.Task)
+	}
+}
+
+func TestBuildExpressionTasksFoldsNegatedLiterals(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expr, err := BuildExpressionTasks("2*-3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	root := tasksStore[expr.RootTaskID]
+	if len(tasksStore) != 1 || root.Operator != "*" || *root.Args[0] != 2 || *root.Args[1] != -3 {
+		t.Fatalf("expected a single product task with the negated literal, got %d tasks, root %+v", len(tasksStore), root)
+	}
+
+	expr, err = BuildExpressionTasks("--5")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	if expr.Status != "done" || *expr.Result != 5 {
+		t.Errorf("expected a negated literal to be done right away, got %+v", expr)
+	}
+}
+
+func TestBuildExpressionTasksPower(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expr, err := BuildExpressionTasks("2^3^2")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	root := tasksStore[expr.RootTaskID]
+	// Right associativity: the root computes 2^(3^2), waiting for the 3^2 task.
+	if root.Operator != "^" || root.Args[0] == nil || *root.Args[0] != 2 || root.DepTasks[1] == "" {
+		t.Fatalf("expected root task 2^(3^2), got %+v", root)
+	}
+	if dep := tasksStore[root.DepTasks[1]]; dep.Operator != "^" || dep.OperationTime != PowerTimeMs {
+		t.Errorf("expected power dependency task, got %+v", dep)
+	}
+}
+
+func TestBuildExpressionTasksFunctions(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expr, err := BuildExpressionTasks("max(sqrt(16), 2*3, 1)")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	root := tasksStore[expr.RootTaskID]
+	if root.Operator != "max" || len(root.Args) != 3 || root.OperationTime != FunctionTimeMs {
+		t.Fatalf("expected 3-argument max root task, got %+v", root)
+	}
+	if root.DepTasks[0] == "" || root.DepTasks[1] == "" || root.Args[2] == nil || *root.Args[2] != 1 {
+		t.Fatalf("expected two dependency tasks and a literal argument, got %+v", root)
+	}
+	if updateTaskDependencies(root) {
+		t.Error("expected root task to wait for its dependencies")
+	}
+	for i, result := range []float64{4, 6} {
+		dep := tasksStore[root.DepTasks[i]]
+		dep.Status = "done"
+		dep.Result = float64Ptr(result)
+	}
+	if !updateTaskDependencies(root) || *root.Args[0] != 4 || *root.Args[1] != 6 {
+		t.Errorf("expected dependency results to be assigned, got %+v", root)
+	}
+}
+
+func TestHandleCalculateParseError(t *testing.T) {
+	reqBody := `{"expression": "2 + (3 * 4"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(reqBody))
+	w := httptest.NewRecorder()
+
+	handleCalculate(w, req)
+	res := w.Result()
+
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d, got %d", http.StatusUnprocessableEntity, res.StatusCode)
+	}
+	var resp struct {
+		Code     string `json:"code"`
+		Message  string `json:"message"`
+		Position int    `json:"position"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Code != "mismatched_parenthesis" || resp.Position != 4 || resp.Message == "" {
+		t.Errorf("unexpected error response: %+v", resp)
+	}
+}
+
+func TestHandleCancelExpression(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expressionsStore["expr1"] = &Expression{ID: "expr1", Expr: "2+2", Status: "pending", RootTaskID: "task1"}
+	tasksStore["task1"] = &Task{ID: "task1", ExpressionID: "expr1", Operator: "+", Status: "running", LeaseID: "lease1"}
+	rebuildScheduler()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/expressions/expr1/cancel", nil)
+	w := httptest.NewRecorder()
+
+	expressionHandler(w, req)
+	res := w.Result()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if expr := expressionsStore["expr1"]; expr.Status != "cancelled" {
+		t.Errorf("expected expression to be cancelled, got %q", expr.Status)
+	}
+
+	// A late result from the agent is rejected
+	reqBody := `{"id": "task1", "lease_id": "lease1", "result": 4}`
+	req = httptest.NewRequest(http.MethodPost, "/internal/task", strings.NewReader(reqBody))
+	w = httptest.NewRecorder()
+
+	handlePostTask(w, req)
+	res = w.Result()
+
+	if res.StatusCode != http.StatusGone {
+		t.Errorf("expected status %d, got %d", http.StatusGone, res.StatusCode)
+	}
+}
+
+func TestHandleDeleteExpression(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	expressionsStore["expr1"] = &Expression{ID: "expr1", Expr: "2+2", Status: "done", Result: float64Ptr(4)}
+	tasksStore["task1"] = &Task{ID: "task1", ExpressionID: "expr1", Operator: "+", Status: "done"}
+	tasksStore["other"] = &Task{ID: "other", ExpressionID: "expr2", Operator: "+", Status: "pending"}
+	rebuildScheduler()
+
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/expressions/expr1", nil)
+	w := httptest.NewRecorder()
+
+	expressionHandler(w, req)
+	res := w.Result()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	if _, ok := expressionsStore["expr1"]; ok {
+		t.Error("expected expression to be deleted")
+	}
+	if _, ok := tasksStore["task1"]; ok {
+		t.Error("expected tasks of the expression to be deleted")
+	}
+	if _, ok := tasksStore["other"]; !ok {
+		t.Error("expected tasks of other expressions to be kept")
+	}
+}
+
+func TestHandleGetTaskLongPoll(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+
+	req := httptest.NewRequest(http.MethodGet, "/internal/task?wait=10ms", nil)
+	w := httptest.NewRecorder()
+
+	handleGetTask(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected status %d after wait elapsed, got %d", http.StatusNotFound, w.Code)
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/internal/task?wait=5s", nil)
+		w := httptest.NewRecorder()
+		handleGetTask(w, req)
+		done <- w
+	}()
+	time.Sleep(20 * time.Millisecond)
+	start := time.Now()
+	if _, err := BuildExpressionTasks("2+2"); err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+
+	select {
+	case w := <-done:
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("waiting request answered too late: %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting request was not woken up by the new expression")
+	}
+}
+
+func TestAgentRegistry(t *testing.T) {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	agentsStore = make(map[string]*Agent)
+	rebuildScheduler()
+
+	reqBody := `{"id": "agent1", "hostname": "host", "workers": 2, "operations": ["+", "-"]}`
+	req := httptest.NewRequest(http.MethodPost, "/internal/agents/register", strings.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	handleRegisterAgent(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	if _, err := BuildExpressionTasks("2+2"); err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	req = httptest.NewRequest(http.MethodGet, "/internal/task?agent_id=agent1", nil)
+	w = httptest.NewRecorder()
+	handleGetTask(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil)
+	w = httptest.NewRecorder()
+	handleListAgents(w, req)
+	var resp struct {
+		Agents []Agent `json:"agents"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if len(resp.Agents) != 1 || resp.Agents[0].InFlight != 1 || resp.Agents[0].Status != "alive" {
+		t.Fatalf("expected one alive agent with one task in flight, got %+v", resp.Agents)
+	}
+
+	// The agent stops sending heartbeats
+	reapDeadAgents(time.Now().Add(time.Duration(AgentTimeoutMs+1) * time.Millisecond))
+	if agentsStore["agent1"].Status != "dead" {
+		t.Errorf("expected agent to be dead, got %q", agentsStore["agent1"].Status)
+	}
+	for _, task := range tasksStore {
+		if task.Status != "pending" || task.AgentID != "" {
+			t.Errorf("expected task of dead agent to be re-queued, got %+v", task)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/internal/agents/heartbeat", strings.NewReader(`{"id": "unknown"}`))
+	w = httptest.NewRecorder()
+	handleHeartbeat(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for unknown agent, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestExpressionEvents(t *testing.T) {
+	resetScheduler()
+	ts := httptest.NewServer(LoggingMiddleware(http.HandlerFunc(expressionHandler)))
+	defer ts.Close()
+
+	expr, err := BuildExpressionTasks("2+3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	resp, err := http.Get(ts.URL + "/api/v1/expressions/" + expr.ID + "/events")
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("expected text/event-stream, got %q", ct)
+	}
+
+	storeMutex.Lock()
+	task := takeTask(time.Now(), "")
+	storeMutex.Unlock()
+	if _, err := submitResult(task.ID, task.LeaseID, 5, ""); err != nil {
+		t.Fatalf("failed to submit result: %v", err)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read event stream: %v", err)
+	}
+	events := strings.Split(strings.TrimSpace(string(body)), "\n\n")
+	if len(events) != 2 {
+		t.Fatalf("expected a progress and a final event, got %q", body)
+	}
+	if !strings.HasPrefix(events[0], "event: progress\n") || !strings.Contains(events[0], `"completed_tasks":1,"operation":"+","result":5,"task_id":"`+task.ID+`","total_tasks":1`) {
+		t.Errorf("unexpected progress event %q", events[0])
+	}
+	if !strings.HasPrefix(events[1], "event: done\n") || !strings.Contains(events[1], `"result":5`) {
+		t.Errorf("unexpected final event %q", events[1])
+	}
+
+	// A finished expression gets its final event right away
+	resp, err = http.Get(ts.URL + "/api/v1/expressions/" + expr.ID + "/events")
+	if err != nil {
+		t.Fatalf("failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if !strings.HasPrefix(string(body), "event: done\n") {
+		t.Errorf("expected final event for a finished expression, got %q", body)
+	}
+}
+
+func TestHandleCalculateBatch(t *testing.T) {
+	resetScheduler()
+	reqBody := `{"expressions": ["2+2", "2+", "7"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate/batch", strings.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	handleCalculateBatch(w, req)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	var resp struct {
+		BatchID string `json:"batch_id"`
+		Items   []struct {
+			Index int            `json:"index"`
+			ID    string         `json:"id"`
+			Error map[string]any `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.BatchID == "" || len(resp.Items) != 3 {
+		t.Fatalf("expected a batch id and three items, got %+v", resp)
+	}
+	if resp.Items[0].ID == "" || resp.Items[2].ID == "" {
+		t.Errorf("expected valid expressions to get ids, got %+v", resp.Items)
+	}
+	if resp.Items[1].ID != "" || resp.Items[1].Error["code"] != "missing_operand" {
+		t.Errorf("expected a structured error for the invalid expression, got %+v", resp.Items[1])
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/batches/"+resp.BatchID, nil)
+	w = httptest.NewRecorder()
+	handleGetBatch(w, req)
+	var batchResp struct {
+		Batch Batch `json:"batch"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&batchResp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	batch := batchResp.Batch
+	if batch.Status != "pending" || batch.Total != 2 || batch.Pending != 1 || batch.Done != 1 {
+		t.Errorf("expected one pending and one done expression, got %+v", batch)
+	}
+	if len(batch.Expressions) != 2 || batch.Expressions[0].ID != resp.Items[0].ID || batch.Expressions[1].ID != resp.Items[2].ID {
+		t.Errorf("expected the expressions in submission order, got %+v", batch.Expressions)
+	}
+
+	storeMutex.Lock()
+	rebuildExpressionIndex()
+	if ids := batchIndex[resp.BatchID]; len(ids) != 2 || ids[0] != resp.Items[0].ID || ids[1] != resp.Items[2].ID {
+		t.Errorf("expected the rebuilt batch index in submission order, got %v", ids)
+	}
+	deleteExpression(resp.Items[0].ID)
+	if batch := aggregateBatch(resp.BatchID, ""); batch == nil || batch.Total != 1 || batch.Expressions[0].ID != resp.Items[2].ID {
+		t.Errorf("expected the deleted expression to leave the batch, got %+v", batch)
+	}
+	storeMutex.Unlock()
+
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/batches/unknown", nil)
+	w = httptest.NewRecorder()
+	handleGetBatch(w, req)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for an unknown batch, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestHandleCalculateIdempotencyKey(t *testing.T) {
+	defer func(path string) { StoragePath = path }(StoragePath)
+	StoragePath = t.TempDir()
+	if err := openStorage(); err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	defer func() {
+		storage.Close()
+		storage = memoryStorage{}
+	}()
+	calculate := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(body))
+		req.Header.Set("Idempotency-Key", "key1")
+		w := httptest.NewRecorder()
+		handleCalculate(w, req)
+		return w
+	}
+
+	first := calculate(`{"expression": "2+2"}`)
+	if first.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, first.Code)
+	}
+	// Keys survive a restart
+	if err := storage.Close(); err != nil {
+		t.Fatalf("failed to close storage: %v", err)
+	}
+	if err := openStorage(); err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	retry := calculate(`{"expression": "2+2"}`)
+	if retry.Code != http.StatusCreated || retry.Body.String() != first.Body.String() {
+		t.Fatalf("expected the retry to return the original id %q, got %d %q", first.Body, retry.Code, retry.Body)
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("expected the retry to be marked as replayed")
+	}
+	if len(expressionsStore) != 1 {
+		t.Errorf("expected a single expression, got %d", len(expressionsStore))
+	}
+
+	if w := calculate(`{"expression": "3+3"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected status %d for a different body, got %d", http.StatusConflict, w.Code)
+	}
+
+	// Once the key expires, it can be used for a new request
+	storeMutex.Lock()
+	expireIdempotencyKeys(time.Now().Add(time.Duration(IdempotencyKeyTTLMs+1) * time.Millisecond))
+	storeMutex.Unlock()
+	if w := calculate(`{"expression": "3+3"}`); w.Code != http.StatusCreated {
+		t.Errorf("expected status %d after the key expired, got %d", http.StatusCreated, w.Code)
+	}
+}
+
+func TestCompletionWebhook(t *testing.T) {
+	resetScheduler()
+	defer func(secret string, backoff int) { WebhookSecret, WebhookBackoffMs = secret, backoff }(WebhookSecret, WebhookBackoffMs)
+	WebhookSecret, WebhookBackoffMs = "secret", 1
+	// The receiver runs on the loopback interface
+	defer func(networks []netip.Prefix) { allowedWebhookNetworks = networks }(allowedWebhookNetworks)
+	allowedWebhookNetworks = parseNetworks("127.0.0.0/8")
+
+	received := make(chan []byte, 1)
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			// The first delivery fails and has to be retried
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Calculator-Signature") != signWebhook(body) {
+			t.Errorf("unexpected signature %q", r.Header.Get("X-Calculator-Signature"))
+		}
+		received <- body
+	}))
+	defer receiver.Close()
+
+	reqBody := `{"expression": "2+3", "callback_url": "` + receiver.URL + `"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(reqBody))
+	w := httptest.NewRecorder()
+	handleCalculate(w, req)
+	var resp map[string]string
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	storeMutex.Lock()
+	task := takeTask(time.Now(), "")
+	storeMutex.Unlock()
+	if _, err := submitResult(task.ID, task.LeaseID, 5, ""); err != nil {
+		t.Fatalf("failed to submit result: %v", err)
+	}
+
+	select {
+	case body := <-received:
+		var payload struct {
+			Expression Expression `json:"expression"`
+		}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Fatalf("failed to decode webhook: %v", err)
+		}
+		if payload.Expression.ID != resp["id"] || payload.Expression.Status != "done" || *payload.Expression.Result != 5 {
+			t.Errorf("unexpected webhook payload %s", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	// The delivery log is updated right after the receiver answers
+	var deliveryLog struct {
+		Status     string            `json:"status"`
+		Deliveries []WebhookDelivery `json:"deliveries"`
+	}
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		req = httptest.NewRequest(http.MethodGet, "/api/v1/expressions/"+resp["id"]+"/webhooks", nil)
+		w = httptest.NewRecorder()
+		expressionHandler(w, req)
+		json.NewDecoder(w.Body).Decode(&deliveryLog)
+		if deliveryLog.Status != "pending" {
+			break
+		}
+	}
+	if deliveryLog.Status != "delivered" || len(deliveryLog.Deliveries) != 2 || deliveryLog.Deliveries[0].StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected a failed and a successful delivery, got %+v", deliveryLog)
+	}
+
+	for _, callbackURL := range []string{"ftp://host", "http://169.254.169.254/latest/meta-data", "http://[::1]:8080", "http://localhost"} {
+		req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+1", "callback_url": "`+callbackURL+`"}`))
+		w = httptest.NewRecorder()
+		handleCalculate(w, req)
+		if w.Code != http.StatusUnprocessableEntity {
+			t.Errorf("expected status %d for callback_url %s, got %d", http.StatusUnprocessableEntity, callbackURL, w.Code)
+		}
+	}
+
+	// Webhooks are always signed, so they require a secret
+	WebhookSecret = ""
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(reqBody))
+	w = httptest.NewRecorder()
+	handleCalculate(w, req)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d for a callback_url without WEBHOOK_SECRET, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+}
+
+func TestWebhookAddressAllowed(t *testing.T) {
+	defer func(networks []netip.Prefix) { allowedWebhookNetworks = networks }(allowedWebhookNetworks)
+	allowedWebhookNetworks = parseNetworks("10.1.0.0/16, invalid")
+	for addr, allowed := range map[string]bool{
+		"93.184.216.34":          true,
+		"2606:2800:220:1::248":   true,
+		"10.1.2.3":               true,
+		"10.2.0.1":               false,
+		"127.0.0.1":              false,
+		"::1":                    false,
+		"::ffff:127.0.0.1":       false,
+		"169.254.169.254":        false,
+		"fe80::1":                false,
+		"192.168.1.1":            false,
+		"172.16.0.1":             false,
+		"fd00::1":                false,
+		"100.64.0.1":             false,
+		"0.0.0.0":                false,
+		"::":                     false,
+		"224.0.0.1":              false,
+		"::ffff:169.254.169.254": false,
+	} {
+		if got := webhookAddressAllowed(netip.MustParseAddr(addr)); got != allowed {
+			t.Errorf("webhookAddressAllowed(%s) = %v, want %v", addr, got, allowed)
+		}
+	}
+
+	// Names resolving to a forbidden address are refused when connecting
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("webhook delivered to a loopback address")
+	}))
+	defer receiver.Close()
+	defer func(secret string) { WebhookSecret = secret }(WebhookSecret)
+	WebhookSecret = "secret"
+	callbackURL := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	if _, err := postWebhook("expr1", callbackURL, []byte("{}")); !errors.Is(err, errForbiddenAddress) {
+		t.Errorf("expected the connection to be refused, got %v", err)
+	}
+}
+
+func TestRegisterAndLogin(t *testing.T) {
+	usersStore = make(map[string]*User)
+	post := func(handler http.HandlerFunc, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if w := post(handleRegister, "/api/v1/register", `{"login": "alice", "password": "correct horse"}`); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if strings.Contains(usersStore["alice"].PasswordHash, "correct horse") {
+		t.Error("expected the password to be stored hashed")
+	}
+	if w := post(handleRegister, "/api/v1/register", `{"login": "alice", "password": "another one"}`); w.Code != http.StatusConflict {
+		t.Errorf("expected status %d for a taken login, got %d", http.StatusConflict, w.Code)
+	}
+	if w := post(handleRegister, "/api/v1/register", `{"login": "bob", "password": "short"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("expected status %d for a short password, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+	if w := post(handleLogin, "/api/v1/login", `{"login": "alice", "password": "wrong password"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a wrong password, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w := post(handleLogin, "/api/v1/login", `{"login": "alice", "password": "correct horse"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	claims, err := parseToken(resp["token"], time.Now())
+	if err != nil || claims.Subject != usersStore["alice"].ID {
+		t.Errorf("expected a token for alice, got %+v, %v", claims, err)
+	}
+	if _, err := parseToken(resp["token"], time.Now().Add(time.Duration(JWTTTLMs)*time.Millisecond)); err == nil {
+		t.Error("expected the token to expire")
+	}
+	if _, err := parseToken(resp["token"]+"x", time.Now()); err == nil {
+		t.Error("expected a tampered token to be rejected")
+	}
+}
+
+func TestExpressionsScopedToOwner(t *testing.T) {
+	resetScheduler()
+	usersStore = make(map[string]*User)
+	tokens := make(map[string]string)
+	for _, login := range []string{"alice", "bob"} {
+		user := &User{ID: login + "-id", Login: login}
+		usersStore[login] = user
+		token, err := issueToken(user, time.Now())
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+		tokens[login] = token
+	}
+	handler := AuthMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/calculate" {
+			handleCalculate(w, r)
+		} else if r.URL.Path == "/api/v1/expressions" {
+			handleListExpressions(w, r)
+		} else {
+			expressionHandler(w, r)
+		}
+	}))
+	do := func(login, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if login != "" {
+			req.Header.Set("Authorization", "Bearer "+tokens[login])
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("", http.MethodGet, "/api/v1/expressions", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d without a token, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	w := do("alice", http.MethodPost, "/api/v1/calculate", `{"expression": "2+2"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+	var created map[string]string
+	json.NewDecoder(w.Body).Decode(&created)
+
+	if w := do("alice", http.MethodGet, "/api/v1/expressions/"+created["id"], ""); w.Code != http.StatusOK {
+		t.Errorf("expected the owner to get the expression, got status %d", w.Code)
+	}
+	if w := do("bob", http.MethodGet, "/api/v1/expressions/"+created["id"], ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d for another user's expression, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := do("bob", http.MethodDelete, "/api/v1/expressions/"+created["id"], ""); w.Code != http.StatusNotFound {
+		t.Errorf("expected status %d deleting another user's expression, got %d", http.StatusNotFound, w.Code)
+	}
+
+	for login, want := range map[string]int{"alice": 1, "bob": 0} {
+		var resp struct {
+			Expressions []Expression `json:"expressions"`
+		}
+		json.NewDecoder(do(login, http.MethodGet, "/api/v1/expressions", "").Body).Decode(&resp)
+		if len(resp.Expressions) != want {
+			t.Errorf("expected %s to see %d expressions, got %d", login, want, len(resp.Expressions))
+		}
+	}
+	if index := expressionIndex["bob-id"]; index != nil {
+		t.Errorf("expected no index for a user without expressions, got %+v", index)
+	}
+
+	if w := do("alice", http.MethodDelete, "/api/v1/expressions/"+created["id"], ""); w.Code != http.StatusOK {
+		t.Fatalf("expected status %d deleting own expression, got %d", http.StatusOK, w.Code)
+	}
+	if index := expressionIndex["alice-id"]; index != nil {
+		t.Errorf("expected the index of alice to be dropped with its last expression, got %+v", index)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	resetScheduler()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/api/v1/calculate", LoggingMiddleware(http.HandlerFunc(handleCalculate)))
+	mux.Handle("/internal/task", LoggingMiddleware(http.HandlerFunc(internalTaskHandler)))
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	do(http.MethodPost, "/api/v1/calculate", `{"expression": "2+3"}`)
+	var taskResp struct {
+		Task assignment `json:"task"`
+	}
+	json.NewDecoder(do(http.MethodGet, "/internal/task", "").Body).Decode(&taskResp)
+	do(http.MethodPost, "/internal/task", fmt.Sprintf(`{"id": %q, "lease_id": %q, "result": 5}`, taskResp.Task.ID, taskResp.Task.LeaseID))
+
+	body := do(http.MethodGet, "/metrics", "").Body.String()
+	for _, want := range []string{
+		`calculator_expressions{status="done"} 1`,
+		`calculator_tasks{status="done"} 1`,
+		`calculator_tasks_queued 0`,
+		`calculator_task_dispatch_latency_seconds_count`,
+		`calculator_task_compute_seconds_count{operation="+"}`,
+		`calculator_http_request_duration_seconds_count{code="201",method="POST",route="/api/v1/calculate"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in metrics:\n%s", want, body)
+		}
+	}
+
+	// The counts follow status changes and deletions without scanning the stores
+	var created map[string]string
+	json.NewDecoder(do(http.MethodPost, "/api/v1/calculate", `{"expression": "1+2*3"}`).Body).Decode(&created)
+	do(http.MethodGet, "/internal/task", "")
+	w := httptest.NewRecorder()
+	expressionHandler(w, httptest.NewRequest(http.MethodPost, "/api/v1/expressions/"+created["id"]+"/cancel", nil))
+	w = httptest.NewRecorder()
+	expressionHandler(w, httptest.NewRequest(http.MethodDelete, "/api/v1/expressions/"+taskResp.Task.ExpressionID, nil))
+
+	body = do(http.MethodGet, "/metrics", "").Body.String()
+	for _, want := range []string{
+		`calculator_expressions{status="done"} 0`,
+		`calculator_expressions{status="cancelled"} 1`,
+		`calculator_tasks{status="done"} 0`,
+		`calculator_tasks{status="running"} 0`,
+		`calculator_tasks{status="cancelled"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in metrics:\n%s", want, body)
+		}
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	counted := make(map[string]int)
+	for _, task := range tasksStore {
+		counted[task.Status]++
+	}
+	for status, n := range taskStatuses {
+		if counted[status] != n {
+			t.Errorf("counted %d tasks with status %s, the store has %d", n, status, counted[status])
+		}
+	}
+}
+
+func TestLoggingRequestID(t *testing.T) {
+	resetScheduler()
+	var buf bytes.Buffer
+	defer func(l *slog.Logger) { logger = l }(logger)
+	logger = newLogger(&buf, "info", "json")
+
+	handler := LoggingMiddleware(http.HandlerFunc(handleCalculate))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2"}`))
+	req.Header.Set("X-Request-ID", "req-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-ID"); got != "req-42" {
+		t.Errorf("expected the request id to be echoed, got %q", got)
+	}
+
+	var submitted bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON log lines, got %q", line)
+		}
+		if record["request_id"] != "req-42" {
+			t.Errorf("expected request_id in every line, got %q", line)
+		}
+		if record["msg"] == "expression submitted" && record["expression_id"] != "" {
+			submitted = true
+		}
+	}
+	if !submitted {
+		t.Errorf("expected the submission to be logged with the expression id, got:\n%s", buf.String())
+	}
+
+	// Without a usable id from the client a new one is generated
+	req = httptest.NewRequest(http.MethodPost, "/api/v1/calculate", strings.NewReader(`{"expression": "1+2"}`))
+	req.Header.Set("X-Request-ID", "bad\nid")
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header().Get("X-Request-ID"); got == "" || got == "bad\nid" {
+		t.Errorf("expected a generated request id, got %q", got)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	resetScheduler()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	defer func(t trace.Tracer) { tracer = t }(tracer)
+	tracer = provider.Tracer("test")
+
+	expr, err := BuildExpressionTasks("2*3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	w := httptest.NewRecorder()
+	handleGetTask(w, httptest.NewRequest(http.MethodGet, "/internal/task", nil))
+	var resp struct {
+		Task assignment `json:"task"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+
+	// The agent computes the task as a child of the dispatch span and reports the result
+	ctx, compute := tracer.Start(propagator.Extract(context.Background(), propagation.HeaderCarrier(w.Header())), "compute task")
+	compute.End()
+	req := httptest.NewRequest(http.MethodPost, "/internal/task",
+		strings.NewReader(fmt.Sprintf(`{"id": %q, "lease_id": %q, "result": 6}`, resp.Task.ID, resp.Task.LeaseID)))
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+	w = httptest.NewRecorder()
+	handlePostTask(w, req)
+	if w.Code != http.StatusOK || expr.Status != "done" {
+		t.Fatalf("expected the result to be recorded, got %d %s", w.Code, w.Body.String())
+	}
+
+	spans := exporter.GetSpans()
+	byName := make(map[string]tracetest.SpanStub)
+	for _, s := range spans {
+		byName[s.Name] = s
+	}
+	build, dispatch, result := byName["BuildExpressionTasks"], byName["dispatch task"], byName["record result"]
+	if len(spans) != 4 || !build.SpanContext.IsValid() || !dispatch.SpanContext.IsValid() || !result.SpanContext.IsValid() {
+		t.Fatalf("expected build, dispatch, compute and result spans, got %+v", spans)
+	}
+	for _, s := range spans {
+		if s.SpanContext.TraceID() != build.SpanContext.TraceID() {
+			t.Errorf("expected all spans in the trace of the expression, got %+v", s)
+		}
+	}
+	if build.Parent.IsValid() || dispatch.Parent.SpanID() != build.SpanContext.SpanID() ||
+		byName["compute task"].Parent.SpanID() != dispatch.SpanContext.SpanID() ||
+		result.Parent.SpanID() != byName["compute task"].SpanContext.SpanID() {
+		t.Errorf("unexpected span hierarchy %+v", spans)
+	}
+}
+
+func TestAgentEndpointsOnlyOnInternalListener(t *testing.T) {
+	resetScheduler()
+	public, internal := publicHandler(), internalHandler()
+	for _, path := range []string{"/internal/task", "/internal/task/release", "/internal/agents/register", "/internal/agents/heartbeat", "/api/v1/agents"} {
+		w := httptest.NewRecorder()
+		public.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected %s not to be served on the public listener, got status %d", path, w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	internal.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the agents to be listed on the internal listener, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	internal.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/expressions", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected the user API not to be served on the internal listener, got status %d", w.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package orchestrator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// maxIdempotencyKeyLength limits the length of the Idempotency-Key header.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyRecord remembers the expression created for an Idempotency-Key.
+// Records are saved to the storage once the expression is created.
+type idempotencyRecord struct {
+	Key          string    `json:"id"`
+	RequestHash  string    `json:"request_hash"`            // hex-encoded SHA-256 of the request
+	ExpressionID string    `json:"expression_id,omitempty"` // empty while the first request with the key is still being processed
+	Expires      time.Time `json:"expires"`
+}
+
+// idempotencyKeys holds the keys seen within IdempotencyKeyTTLMs. Access is guarded by storeMutex.
+var idempotencyKeys = make(map[string]*idempotencyRecord)
+
+var (
+	errIdempotencyMismatch   = errors.New("idempotency key reused with a different request")
+	errIdempotencyInProgress = errors.New("request with this idempotency key is in progress")
+)
+
+// reserveIdempotencyKey looks up the key for a request. It returns the id of the expression
+// created by an earlier request with the same key and body, or "" if the key is new, in which
+// case the key is reserved until finishIdempotencyKey or releaseIdempotencyKey is called.
+func reserveIdempotencyKey(key string, request []byte, now time.Time) (string, error) {
+	sum := sha256.Sum256(request)
+	hash := hex.EncodeToString(sum[:])
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if rec, ok := idempotencyKeys[key]; ok && now.Before(rec.Expires) {
+		if rec.RequestHash != hash {
+			return "", errIdempotencyMismatch
+		}
+		if rec.ExpressionID == "" {
+			return "", errIdempotencyInProgress
+		}
+		return rec.ExpressionID, nil
+	}
+	idempotencyKeys[key] = &idempotencyRecord{
+		Key:         key,
+		RequestHash: hash,
+		Expires:     now.Add(time.Duration(IdempotencyKeyTTLMs) * time.Millisecond),
+	}
+	return "", nil
+}
+
+// finishIdempotencyKey records the expression created for the reserved key and saves the key,
+// so that retries are recognized after a restart too.
+func finishIdempotencyKey(key, expressionID string) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if rec, ok := idempotencyKeys[key]; ok {
+		rec.ExpressionID = expressionID
+		if err := storage.SaveIdempotencyKey(rec); err != nil {
+			logger.Error("saving idempotency key", "expression_id", expressionID, "error", err)
+		}
+	}
+}
+
+// releaseIdempotencyKey forgets the reserved key after the request failed, so that it can be retried.
+func releaseIdempotencyKey(key string) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	delete(idempotencyKeys, key)
+}
+
+// expireIdempotencyKeys drops keys whose retention window has passed. Caller must hold storeMutex.
+func expireIdempotencyKeys(now time.Time) {
+	for key, rec := range idempotencyKeys {
+		if !now.Before(rec.Expires) {
+			delete(idempotencyKeys, key)
+			if rec.ExpressionID == "" {
+				continue // never saved
+			}
+			if err := storage.DeleteIdempotencyKey(key); err != nil {
+				logger.Error("deleting idempotency key", "expression_id", rec.ExpressionID, "error", err)
+			}
+		}
+	}
+}
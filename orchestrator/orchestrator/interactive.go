@@ -0,0 +1,187 @@
+package orchestrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
+	"github.com/coder/websocket"
+)
+
+const (
+	// wsPingInterval is how often an interactive connection is pinged to detect clients that are gone.
+	wsPingInterval = 30 * time.Second
+	// wsWriteTimeout limits how long a write may wait for a client that doesn't read.
+	wsWriteTimeout = 10 * time.Second
+	// maxWebSocketMessage limits the size of a message received from a client.
+	maxWebSocketMessage = 64 << 10
+)
+
+// wsOriginPatterns are the origins besides the orchestrator's own that may open interactive connections.
+var wsOriginPatterns = parseOriginPatterns(WebSocketAllowedOrigins)
+
+// parseOriginPatterns splits the comma-separated WS_ALLOWED_ORIGINS.
+func parseOriginPatterns(list string) []string {
+	var patterns []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			patterns = append(patterns, item)
+		}
+	}
+	return patterns
+}
+
+// wsRequest is a message sent by a client of the interactive API.
+type wsRequest struct {
+	Type       string `json:"type"` // "calculate" or "subscribe"
+	RequestID  string `json:"request_id,omitempty"`
+	Expression string `json:"expression,omitempty"`
+	ID         string `json:"id,omitempty"`
+}
+
+// handleWebSocket handles GET /api/v1/ws, the interactive API. Over a single WebSocket connection
+// the client submits expressions (or follows existing ones) and receives the same events as
+// GET /api/v1/expressions/:id/events, each tagged with the expression id.
+// Pages on other origins are refused unless listed in WS_ALLOWED_ORIGINS, so that a site the
+// user visits can't drive the API with the user's credentials.
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := websocket.Accept(w, r, &websocket.AcceptOptions{OriginPatterns: wsOriginPatterns})
+	if err != nil {
+		logger.Debug("websocket upgrade refused", "error", err)
+		return
+	}
+	defer conn.CloseNow()
+	conn.SetReadLimit(maxWebSocketMessage)
+	done := make(chan struct{})
+	defer close(done)
+	go pingWebSocket(conn, done)
+	go func() {
+		// The request context is cancelled when the orchestrator shuts down
+		select {
+		case <-r.Context().Done():
+			conn.Close(websocket.StatusGoingAway, "orchestrator is shutting down")
+		case <-done:
+		}
+	}()
+
+	for {
+		// Reads are not bound to the request context, so that a shutdown closes the connection
+		// with a close frame rather than dropping it
+		_, data, err := conn.Read(context.Background())
+		if err != nil {
+			return
+		}
+		var req wsRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			writeWebSocketJSON(conn, map[string]any{"type": "error", "error": map[string]any{"message": "invalid message"}})
+			continue
+		}
+		switch req.Type {
+		case "calculate":
+			if shuttingDown.Load() {
+				writeWebSocketJSON(conn, map[string]any{"type": "error", "request_id": req.RequestID, "error": map[string]any{"message": errShuttingDown}})
+				continue
+			}
+			expr, err := submitExpression(r.Context(), &Expression{Expr: req.Expression, Owner: requestOwner(r)})
+			if err != nil {
+				body := map[string]any{"message": "error processing expression"}
+				var parseErr *calculator.ParseError
+				if errors.As(err, &parseErr) {
+					body = parseErrorBody(parseErr)
+				}
+				writeWebSocketJSON(conn, map[string]any{"type": "error", "request_id": req.RequestID, "error": body})
+				continue
+			}
+			followOverWebSocket(conn, req, expr.ID, requestOwner(r), "accepted", done)
+		case "subscribe":
+			followOverWebSocket(conn, req, req.ID, requestOwner(r), "subscribed", done)
+		default:
+			writeWebSocketJSON(conn, map[string]any{
+				"type":       "error",
+				"request_id": req.RequestID,
+				"error":      map[string]any{"message": "unknown message type"},
+			})
+		}
+	}
+}
+
+// followOverWebSocket confirms the request with a reply of the given type and forwards
+// events of the expression to the connection until its final event or until done is closed.
+func followOverWebSocket(conn *websocket.Conn, req wsRequest, exprID, owner, reply string, done <-chan struct{}) {
+	storeMutex.Lock()
+	events, ok := followExpression(exprID, owner)
+	storeMutex.Unlock()
+	if !ok {
+		writeWebSocketJSON(conn, map[string]any{
+			"type":       "error",
+			"request_id": req.RequestID,
+			"error":      map[string]any{"message": "not found"},
+		})
+		return
+	}
+	writeWebSocketJSON(conn, map[string]any{"type": reply, "request_id": req.RequestID, "id": exprID})
+
+	go func() {
+		defer func() {
+			storeMutex.Lock()
+			unsubscribe(exprID, events)
+			storeMutex.Unlock()
+		}()
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				msg := map[string]any{"type": event.Name, "id": exprID}
+				for k, v := range event.Data {
+					msg[k] = v
+				}
+				writeWebSocketJSON(conn, msg)
+				if event.final() {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+}
+
+// writeWebSocketJSON sends the message as JSON text. A failed write means the client is gone,
+// which the read loop of the connection notices as well.
+func writeWebSocketJSON(conn *websocket.Conn, msg map[string]any) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		logger.Error("encoding websocket message", "error", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+	defer cancel()
+	conn.Write(ctx, websocket.MessageText, data)
+}
+
+// pingWebSocket pings the client until done is closed; a client that doesn't answer in time
+// is gone, and closing the connection ends the read loop.
+func pingWebSocket(conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), wsWriteTimeout)
+			err := conn.Ping(ctx)
+			cancel()
+			if err != nil {
+				conn.CloseNow()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package orchestrator
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// grantLease hands the task out to an agent: it marks the task as running,
+// issues a fresh lease id and sets the lease deadline to the operation time plus slack.
+// agentID may be empty for agents that did not register. Caller must hold storeMutex.
+func grantLease(task *Task, now time.Time, agentID string) {
+	setTaskStatus(task, "running")
+	task.AgentID = agentID
+	task.Attempts++
+	task.LeaseID = uuid.New().String()
+	task.LeasedAt = now
+	task.LeaseExpires = now.Add(time.Duration(task.OperationTime+LeaseSlackMs) * time.Millisecond)
+	trackLease(task)
+	saveTask(task)
+}
+
+// leaseEntry is a lease in leaseQueue.
+type leaseEntry struct {
+	expires time.Time
+	taskID  string
+	leaseID string
+}
+
+// leaseHeap is a min-heap of leases by expiry, see container/heap.
+type leaseHeap []leaseEntry
+
+func (h leaseHeap) Len() int           { return len(h) }
+func (h leaseHeap) Less(i, j int) bool { return h[i].expires.Before(h[j].expires) }
+func (h leaseHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *leaseHeap) Push(x any)        { *h = append(*h, x.(leaseEntry)) }
+
+func (h *leaseHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return entry
+}
+
+var (
+	// leaseQueue holds the leases handed out, soonest to expire first, so that the reaper
+	// doesn't look at other tasks. Leases of tasks that are no longer running under them
+	// are dropped once they come up. Access is guarded by storeMutex.
+	leaseQueue leaseHeap
+	// agentTasks maps an agent id to the ids of the tasks it is computing. Access is guarded by storeMutex.
+	agentTasks = make(map[string]map[string]bool)
+)
+
+// trackLease records the lease of a running task. Caller must hold storeMutex.
+func trackLease(task *Task) {
+	heap.Push(&leaseQueue, leaseEntry{expires: task.LeaseExpires, taskID: task.ID, leaseID: task.LeaseID})
+	if task.AgentID == "" {
+		return
+	}
+	tasks, ok := agentTasks[task.AgentID]
+	if !ok {
+		tasks = make(map[string]bool)
+		agentTasks[task.AgentID] = tasks
+	}
+	tasks[task.ID] = true
+}
+
+// untrackLease forgets the agent of a task that is no longer running. Caller must hold storeMutex.
+func untrackLease(task *Task) {
+	if tasks, ok := agentTasks[task.AgentID]; ok {
+		delete(tasks, task.ID)
+		if len(tasks) == 0 {
+			delete(agentTasks, task.AgentID)
+		}
+	}
+}
+
+// reapExpiredLeases returns running tasks with expired leases to "pending".
+// Tasks that have already been handed out MaxTaskAttempts times fail their expression instead.
+// Caller must hold storeMutex.
+func reapExpiredLeases(now time.Time) {
+	for len(leaseQueue) > 0 && !now.Before(leaseQueue[0].expires) {
+		entry := heap.Pop(&leaseQueue).(leaseEntry)
+		if task, ok := tasksStore[entry.taskID]; ok && task.Status == "running" && task.LeaseID == entry.leaseID {
+			requeueTask(task, "task lease expired")
+		}
+	}
+}
+
+// requeueTask takes a running task back from its agent and returns it to "pending".
+// If the task has already been handed out MaxTaskAttempts times, its expression fails
+// with the given reason instead. Caller must hold storeMutex.
+func requeueTask(task *Task, reason string) {
+	logger.Warn("taking task back from agent", "task_id", task.ID, "expression_id", task.ExpressionID,
+		"agent_id", task.AgentID, "reason", reason, "attempts", task.Attempts)
+	if task.Attempts >= MaxTaskAttempts {
+		setTaskStatus(task, "error")
+		task.Error = fmt.Sprintf("%s after %d attempts", reason, task.Attempts)
+		saveTask(task)
+		failExpression(task.ExpressionID, task.Error)
+		return
+	}
+	returnToQueue(task)
+}
+
+// releaseTask takes back a running task from the agent holding its lease at the agent's request,
+// e.g. because the agent is shutting down. Unlike an expired lease, this doesn't count as an attempt.
+// It returns the status to report back to the agent.
+func releaseTask(id, leaseID string) (string, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	task, err := leasedTask(id, leaseID)
+	if err != nil {
+		return "", err
+	}
+	logger.Info("task handed back by agent", "task_id", task.ID, "expression_id", task.ExpressionID, "agent_id", task.AgentID)
+	task.Attempts--
+	returnToQueue(task)
+	return "task released", nil
+}
+
+// returnToQueue makes a running task pending again and queues it for the next agent.
+// Caller must hold storeMutex.
+func returnToQueue(task *Task) {
+	setTaskStatus(task, "pending")
+	task.LeaseID = ""
+	task.AgentID = ""
+	saveTask(task)
+	readyQueue.push(task.ID)
+	notifyTaskReady()
+}
+
+// runLeaseReaper periodically re-queues tasks abandoned by agents,
+// either because their lease expired or because their agent stopped sending heartbeats.
+// It returns once ctx is cancelled.
+func runLeaseReaper(ctx context.Context) {
+	ticker := time.NewTicker(time.Duration(LeaseCheckIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			storeMutex.Lock()
+			reapDeadAgents(now)
+			reapExpiredLeases(now)
+			expireIdempotencyKeys(now)
+			storeMutex.Unlock()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
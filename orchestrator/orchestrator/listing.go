@@ -0,0 +1,227 @@
+package orchestrator
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listKey orders expressions by creation time, ties broken by id.
+type listKey struct {
+	createdAt time.Time
+	id        string
+}
+
+func (k listKey) less(other listKey) bool {
+	if !k.createdAt.Equal(other.createdAt) {
+		return k.createdAt.Before(other.createdAt)
+	}
+	return k.id < other.id
+}
+
+// ownerIndex lists the expressions of one owner in creation order.
+type ownerIndex struct {
+	keys []listKey
+	// deleted counts deleted expressions still present in keys.
+	deleted int
+}
+
+var (
+	// expressionIndex maps an owner to the index of their expressions, so that pages of
+	// GET /api/v1/expressions neither sort the store nor walk other users' expressions.
+	// Deleted expressions are skipped while listing and dropped once they make up half
+	// of an owner's index. Access is guarded by storeMutex.
+	expressionIndex = make(map[string]*ownerIndex)
+	// lastCreatedAt is the creation time of the newest expression.
+	lastCreatedAt time.Time
+	// batchIndex maps a batch id to the ids of its expressions in submission order.
+	batchIndex = make(map[string][]string)
+)
+
+// indexExpression sets the creation time of a new expression and appends it to the indexes.
+// Creation times are kept strictly increasing, so the indexes stay sorted. Caller must hold storeMutex.
+func indexExpression(expr *Expression, now time.Time) {
+	if !now.After(lastCreatedAt) {
+		now = lastCreatedAt.Add(time.Nanosecond)
+	}
+	lastCreatedAt = now
+	expr.CreatedAt = now
+	index := expressionIndex[expr.Owner]
+	if index == nil {
+		index = &ownerIndex{}
+		expressionIndex[expr.Owner] = index
+	}
+	index.keys = append(index.keys, listKey{createdAt: now, id: expr.ID})
+	if expr.BatchID != "" {
+		batchIndex[expr.BatchID] = append(batchIndex[expr.BatchID], expr.ID)
+	}
+}
+
+// unindexExpression accounts for a deleted expression. Caller must hold storeMutex.
+func unindexExpression(expr *Expression) {
+	if expr.BatchID != "" {
+		ids := slices.DeleteFunc(batchIndex[expr.BatchID], func(id string) bool { return id == expr.ID })
+		if len(ids) == 0 {
+			delete(batchIndex, expr.BatchID)
+		} else {
+			batchIndex[expr.BatchID] = ids
+		}
+	}
+	index := expressionIndex[expr.Owner]
+	index.deleted++
+	if index.deleted > len(index.keys)/2 {
+		index.keys = slices.DeleteFunc(index.keys, func(key listKey) bool {
+			_, ok := expressionsStore[key.id]
+			return !ok
+		})
+		index.deleted = 0
+		if len(index.keys) == 0 {
+			delete(expressionIndex, expr.Owner)
+		}
+	}
+}
+
+// rebuildExpressionIndex recreates the indexes and the status counts from expressionsStore, e.g. after loading it
+// from the storage. Caller must hold storeMutex.
+func rebuildExpressionIndex() {
+	keys := make([]listKey, 0, len(expressionsStore))
+	for _, expr := range expressionsStore {
+		keys = append(keys, listKey{createdAt: expr.CreatedAt, id: expr.ID})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j]) })
+	expressionIndex = make(map[string]*ownerIndex)
+	lastCreatedAt = time.Time{}
+	batchIndex = make(map[string][]string)
+	expressionStatuses = make(map[string]int)
+	for _, key := range keys {
+		expr := expressionsStore[key.id]
+		expressionStatuses[expr.Status]++
+		index := expressionIndex[expr.Owner]
+		if index == nil {
+			index = &ownerIndex{}
+			expressionIndex[expr.Owner] = index
+		}
+		index.keys = append(index.keys, key)
+		if expr.BatchID != "" {
+			batchIndex[expr.BatchID] = append(batchIndex[expr.BatchID], key.id)
+		}
+	}
+	if n := len(keys); n > 0 {
+		lastCreatedAt = keys[n-1].createdAt
+	}
+}
+
+// listExpressions returns up to limit expressions of the owner with one of the statuses (any status if empty),
+// oldest first or newest first if desc, starting right after the cursor (from the start if nil).
+// next is the cursor of the following page, nil on the last page. Caller must hold storeMutex.
+func listExpressions(owner string, statuses map[string]bool, limit int, cursor *listKey, desc bool) (page []Expression, next *listKey) {
+	page = []Expression{}
+	index, ok := expressionIndex[owner]
+	if !ok {
+		return page, nil
+	}
+	keys := index.keys
+	i, step := 0, 1
+	if desc {
+		i, step = len(keys)-1, -1
+	}
+	if cursor != nil {
+		// First position past the cursor in the direction of listing
+		i = sort.Search(len(keys), func(j int) bool { return cursor.less(keys[j]) })
+		if desc {
+			i = sort.Search(len(keys), func(j int) bool { return !keys[j].less(*cursor) }) - 1
+		}
+	}
+	for ; i >= 0 && i < len(keys); i += step {
+		expr, ok := expressionsStore[keys[i].id]
+		if !ok || (len(statuses) > 0 && !statuses[expr.Status]) {
+			continue
+		}
+		if len(page) == limit {
+			// There is at least one more matching expression
+			last := keys[i-step]
+			return page, &last
+		}
+		page = append(page, *expr)
+	}
+	return page, nil
+}
+
+// encodeCursor makes an opaque cursor out of a list key.
+func encodeCursor(key listKey) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key.createdAt.UnixNano(), 10) + ":" + key.id))
+}
+
+// decodeCursor parses a cursor made by encodeCursor.
+func decodeCursor(cursor string) (listKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return listKey{}, err
+	}
+	nanos, id, ok := strings.Cut(string(data), ":")
+	if !ok {
+		return listKey{}, errors.New("malformed cursor")
+	}
+	n, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return listKey{}, err
+	}
+	return listKey{createdAt: time.Unix(0, n), id: id}, nil
+}
+
+// listQuery holds the parameters of GET /api/v1/expressions.
+type listQuery struct {
+	statuses map[string]bool
+	limit    int
+	cursor   *listKey
+	desc     bool
+}
+
+// parseListQuery validates the query parameters of GET /api/v1/expressions.
+func parseListQuery(r *http.Request) (listQuery, error) {
+	q := r.URL.Query()
+	query := listQuery{statuses: make(map[string]bool), limit: defaultListLimit}
+	if value := q.Get("status"); value != "" {
+		for _, status := range strings.Split(value, ",") {
+			switch status {
+			case "pending", "done", "error", "cancelled":
+				query.statuses[status] = true
+			default:
+				return query, fmt.Errorf("invalid status %q", status)
+			}
+		}
+	}
+	if value := q.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return query, fmt.Errorf("invalid limit, expected 1 to %d", maxListLimit)
+		}
+		query.limit = limit
+	}
+	if value := q.Get("cursor"); value != "" {
+		key, err := decodeCursor(value)
+		if err != nil {
+			return query, errors.New("invalid cursor")
+		}
+		query.cursor = &key
+	}
+	switch q.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.desc = true
+	default:
+		return query, errors.New("invalid order, expected asc or desc")
+	}
+	return query, nil
+}
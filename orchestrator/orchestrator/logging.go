@@ -0,0 +1,59 @@
+package orchestrator
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logger is the logger of the orchestrator, configured by LOG_LEVEL and LOG_FORMAT.
+// Pass the request context to its *Context methods, so that the request id is logged.
+var logger = newLogger(os.Stderr, LogLevel, LogFormat).With("component", "orchestrator")
+
+// newLogger creates a logger writing "text" or "json" records of the given level and above.
+// Unknown values fall back to "info" and "text".
+func newLogger(w io.Writer, level, format string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	var h slog.Handler = slog.NewTextHandler(w, opts)
+	if strings.EqualFold(format, "json") {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// logAttrsKey is the context key of the attributes added to every record logged with the context.
+type logAttrsKey struct{}
+
+// withLogAttrs returns a context whose log records carry the given attributes
+// (as key-value pairs) in addition to those already in ctx.
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	attrs, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	attrs = append(attrs[:len(attrs):len(attrs)], slog.Group("", args...).Value.Group()...)
+	return context.WithValue(ctx, logAttrsKey{}, attrs)
+}
+
+// contextHandler adds the attributes of withLogAttrs to records.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
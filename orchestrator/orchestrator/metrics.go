@@ -0,0 +1,80 @@
+package orchestrator
+
+import (
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// expressionStatuses and taskStatuses count the stored expressions and tasks by status,
+	// so that scrapes don't walk the stores. Access is guarded by storeMutex.
+	expressionStatuses = make(map[string]int)
+	taskStatuses       = make(map[string]int)
+)
+
+// setExpressionStatus changes the status of a stored expression. Caller must hold storeMutex.
+func setExpressionStatus(expr *Expression, status string) {
+	expressionStatuses[expr.Status]--
+	expressionStatuses[status]++
+	expr.Status = status
+}
+
+// durationBuckets are the histogram buckets of durations; long polls take up to a minute.
+var durationBuckets = slices.Concat(prometheus.DefBuckets, []float64{30, 60})
+
+var (
+	expressionsDesc = prometheus.NewDesc("calculator_expressions", "Expressions by status.", []string{"status"}, nil)
+	tasksDesc       = prometheus.NewDesc("calculator_tasks", "Tasks by status.", []string{"status"}, nil)
+	tasksQueuedDesc = prometheus.NewDesc("calculator_tasks_queued", "Tasks waiting in the ready queue for an agent.", nil, nil)
+	agentsDesc      = prometheus.NewDesc("calculator_agents", "Registered agents.", nil, nil)
+)
+
+// storeCollector reports the sizes of the stores on scrape, under a single lock of storeMutex.
+type storeCollector struct{}
+
+func (storeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- expressionsDesc
+	ch <- tasksDesc
+	ch <- tasksQueuedDesc
+	ch <- agentsDesc
+}
+
+func (storeCollector) Collect(ch chan<- prometheus.Metric) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	for _, status := range []string{"pending", "done", "error", "cancelled"} {
+		ch <- prometheus.MustNewConstMetric(expressionsDesc, prometheus.GaugeValue, float64(expressionStatuses[status]), status)
+	}
+	for _, status := range []string{"pending", "running", "done", "error", "cancelled"} {
+		ch <- prometheus.MustNewConstMetric(tasksDesc, prometheus.GaugeValue, float64(taskStatuses[status]), status)
+	}
+	ch <- prometheus.MustNewConstMetric(tasksQueuedDesc, prometheus.GaugeValue, float64(readyQueue.len()))
+	ch <- prometheus.MustNewConstMetric(agentsDesc, prometheus.GaugeValue, float64(len(agentsStore)))
+}
+
+func init() {
+	prometheus.MustRegister(storeCollector{})
+}
+
+var (
+	// taskDispatchLatency is the time a task spends in the ready queue before an agent takes it.
+	taskDispatchLatency = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "calculator_task_dispatch_latency_seconds",
+		Help:    "Time from a task becoming ready to it being handed out to an agent.",
+		Buckets: durationBuckets,
+	})
+	// taskComputeTime is the time from handing out a task to receiving its result.
+	taskComputeTime = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calculator_task_compute_seconds",
+		Help:    "Time from handing out a task to receiving its result, by operation.",
+		Buckets: durationBuckets,
+	}, []string{"operation"})
+	// httpRequestDuration is recorded by LoggingMiddleware.
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "calculator_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by method, route and status code.",
+		Buckets: durationBuckets,
+	}, []string{"method", "route", "code"})
+)
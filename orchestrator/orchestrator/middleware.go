@@ -1,9 +1,13 @@
 package orchestrator
 
 import (
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
 // ResponseWriterWrapper is a wrapper around http.ResponseWriter to capture the status code.
@@ -18,17 +22,49 @@ func (rw *ResponseWriterWrapper) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// LoggingMiddleware logs the request details and response status code.
+// Unwrap returns the original ResponseWriter, so that http.ResponseController can reach it
+// (e.g. to flush streamed responses).
+func (rw *ResponseWriterWrapper) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// maxRequestIDLength limits the length of an X-Request-ID accepted from the client.
+const maxRequestIDLength = 128
+
+// requestID returns the X-Request-ID of the request if it is reasonable, or a new one.
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if id == "" || len(id) > maxRequestIDLength || strings.ContainsFunc(id, func(c rune) bool { return c < ' ' || c > '~' }) {
+		return uuid.New().String()
+	}
+	return id
+}
+
+// LoggingMiddleware logs the request details and response status code
+// and records the duration of the request in the metrics. Every request gets a request id,
+// returned in the X-Request-ID header and logged with everything logged using the request context.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(withLogAttrs(r.Context(), "request_id", id))
 
-		log.Printf("Request: %s %s", r.Method, r.URL.Path)
+		logger.DebugContext(r.Context(), "request started", "method", r.Method, "path", r.URL.Path)
 
 		rw := &ResponseWriterWrapper{ResponseWriter: w, statusCode: http.StatusOK}
 		next.ServeHTTP(rw, r)
 
-		log.Printf("Completed with status %d in %v", rw.statusCode, time.Since(start))
+		elapsed := time.Since(start)
+		level := slog.LevelInfo
+		if strings.HasPrefix(r.URL.Path, "/internal/") {
+			// Agents poll and report all the time, their requests would flood the log
+			level = slog.LevelDebug
+		}
+		logger.Log(r.Context(), level, "request completed", "method", r.Method, "path", r.URL.Path,
+			"status", rw.statusCode, "duration", elapsed)
+		// The route pattern rather than the path, so that ids don't create a series each
+		httpRequestDuration.WithLabelValues(r.Method, r.Pattern, strconv.Itoa(rw.statusCode)).Observe(elapsed.Seconds())
 	})
 }
 
@@ -36,8 +72,9 @@ func ErrorHandlingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				// Log the error and send a user-friendly message
-				log.Printf("Error occurred: %v", err)
+				// Log the error and send a user-friendly message.
+				// LoggingMiddleware runs inside, so the request id is only found in the response headers.
+				logger.ErrorContext(r.Context(), "panic while handling request", "error", err, "request_id", w.Header().Get("X-Request-ID"))
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
@@ -1,22 +1,96 @@
 package orchestrator
 
 import (
-	"fmt"
-	"log"
+	"context"
+	"errors"
+	"net"
 	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
 )
 
-func RunOrchestrator() {
-	mux := http.NewServeMux()
+// RunOrchestrator serves the orchestrator until ctx is cancelled (e.g. on SIGTERM) and then shuts it
+// down gracefully, see shutdown. It returns an error if the orchestrator could not be started or
+// one of its servers failed; such errors are logged before being returned.
+func RunOrchestrator(ctx context.Context) error {
+	if err := openStorage(); err != nil {
+		logger.Error("opening storage", "error", err)
+		return err
+	}
 
-	mux.Handle("/api/v1/ping", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handlePing))))
-	mux.Handle("/api/v1/calculate", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleCalculate))))
-	mux.Handle("/api/v1/expressions", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleListExpressions))))
-	mux.Handle("/api/v1/expressions/", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleGetExpression))))
-	mux.Handle("/internal/task", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(internalTaskHandler))))
+	reaperCtx, stopReaper := context.WithCancel(context.Background())
+	defer stopReaper()
+	go runLeaseReaper(reaperCtx)
+
+	// Requests get a context of their own, cancelled on shutdown, to end long polls and streams
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	baseContext := func(net.Listener) context.Context { return requestCtx }
+	servers := []*http.Server{
+		{Addr: ":" + Port, Handler: publicHandler(), BaseContext: baseContext},
+		{Addr: ":" + InternalPort, Handler: internalHandler(), BaseContext: baseContext},
+	}
+
+	serveErr := make(chan error, len(servers)+1)
+	for _, server := range servers {
+		go func() {
+			if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serveErr <- err
+			}
+		}()
+	}
+	var grpcServer *grpc.Server
+	if GRPCPort != "" {
+		grpcServer = newGRPCServer(requestCtx)
+		go func() {
+			listener, err := net.Listen("tcp", ":"+GRPCPort)
+			if err == nil {
+				err = grpcServer.Serve(listener)
+			}
+			if err != nil {
+				serveErr <- err
+			}
+		}()
+	}
+	logger.Info("orchestrator is running", "port", Port, "internal_port", InternalPort, "grpc_port", GRPCPort)
 
-	fmt.Printf("Orchestrator is running on %s\n", Port)
-	if err := http.ListenAndServe(":"+Port, mux); err != nil {
-		log.Fatal(err)
+	var err error
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down")
+	case err = <-serveErr:
+		logger.Error("serving requests", "error", err)
 	}
+	stopReaper()
+	shutdown(servers, grpcServer, cancelRequests)
+	return err
+}
+
+// publicHandler serves the API for users on Port.
+func publicHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/api/v1/ping", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handlePing))))
+	mux.Handle("/api/v1/register", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleRegister))))
+	mux.Handle("/api/v1/login", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleLogin))))
+	mux.Handle("/api/v1/calculate", ErrorHandlingMiddleware(LoggingMiddleware(AuthMiddleware(http.HandlerFunc(handleCalculate)))))
+	mux.Handle("/api/v1/calculate/batch", ErrorHandlingMiddleware(LoggingMiddleware(AuthMiddleware(http.HandlerFunc(handleCalculateBatch)))))
+	mux.Handle("/api/v1/batches/", ErrorHandlingMiddleware(LoggingMiddleware(AuthMiddleware(http.HandlerFunc(handleGetBatch)))))
+	mux.Handle("/api/v1/expressions", ErrorHandlingMiddleware(LoggingMiddleware(AuthMiddleware(http.HandlerFunc(handleListExpressions)))))
+	mux.Handle("/api/v1/expressions/", ErrorHandlingMiddleware(LoggingMiddleware(AuthMiddleware(http.HandlerFunc(expressionHandler)))))
+	mux.Handle("/api/v1/ws", ErrorHandlingMiddleware(LoggingMiddleware(AuthMiddleware(http.HandlerFunc(handleWebSocket)))))
+	return mux
+}
+
+// internalHandler serves the agents and the list of agents on InternalPort. They are not authenticated,
+// so the port must only be reachable by agents and operators, like the gRPC server.
+func internalHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/agents", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleListAgents))))
+	mux.Handle("/internal/task", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(internalTaskHandler))))
+	mux.Handle("/internal/task/release", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleReleaseTask))))
+	mux.Handle("/internal/agents/register", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleRegisterAgent))))
+	mux.Handle("/internal/agents/heartbeat", ErrorHandlingMiddleware(LoggingMiddleware(http.HandlerFunc(handleHeartbeat))))
+	return mux
 }
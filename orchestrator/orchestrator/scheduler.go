@@ -0,0 +1,298 @@
+package orchestrator
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	// readyQueue holds ids of tasks whose dependencies are done, in the order they became ready.
+	readyQueue taskQueue
+	// dependents maps a task id to the ids of tasks that use its result.
+	dependents = make(map[string][]string)
+	// taskReady is closed (and replaced) whenever a task may have become ready to be handed out.
+	taskReady = make(chan struct{})
+	// taskCounts maps an expression id to the number of its tasks, reported in progress events.
+	taskCounts = make(map[string]*taskCount)
+	// expressionTasks maps an expression id to the ids of its tasks.
+	expressionTasks = make(map[string][]string)
+)
+
+// taskCount is the number of tasks of an expression and how many of them are done.
+type taskCount struct {
+	total, completed int
+}
+
+// indexTask adds a stored task to the tasks and counts of its expression, the counts by status
+// and, if it is running, the leases. Caller must hold storeMutex.
+func indexTask(task *Task) {
+	expressionTasks[task.ExpressionID] = append(expressionTasks[task.ExpressionID], task.ID)
+	if task.Status == "running" {
+		trackLease(task)
+	}
+	taskStatuses[task.Status]++
+	count, ok := taskCounts[task.ExpressionID]
+	if !ok {
+		count = &taskCount{}
+		taskCounts[task.ExpressionID] = count
+	}
+	count.total++
+	if task.Status == "done" {
+		count.completed++
+	}
+}
+
+// taskQueue is a FIFO queue of task ids.
+type taskQueue struct {
+	entries []queuedTask
+	head    int
+}
+
+// queuedTask is a task id along with the time it was queued.
+type queuedTask struct {
+	id     string
+	queued time.Time
+}
+
+func (q *taskQueue) push(id string) {
+	q.entries = append(q.entries, queuedTask{id: id, queued: time.Now()})
+}
+
+func (q *taskQueue) pop() (queuedTask, bool) {
+	if q.head == len(q.entries) {
+		return queuedTask{}, false
+	}
+	entry := q.entries[q.head]
+	q.entries[q.head] = queuedTask{}
+	q.head++
+	// Drop the consumed part once it dominates the slice
+	if q.head > len(q.entries)/2 {
+		q.entries = append([]queuedTask(nil), q.entries[q.head:]...)
+		q.head = 0
+	}
+	return entry, true
+}
+
+func (q *taskQueue) len() int {
+	return len(q.entries) - q.head
+}
+
+// addTask stores a new task, records it as a dependent of the tasks it waits for
+// and queues it right away if all its arguments are known. Caller must hold storeMutex.
+func addTask(task *Task) {
+	tasksStore[task.ID] = task
+	saveTask(task)
+	indexTask(task)
+	addDependent(task)
+	if updateTaskDependencies(task) {
+		readyQueue.push(task.ID)
+	}
+}
+
+// setTaskStatus changes the status of a stored task, keeping the counts by status
+// and the leases up to date. Caller must hold storeMutex.
+func setTaskStatus(task *Task, status string) {
+	if task.Status == "running" && status != "running" {
+		untrackLease(task)
+	}
+	taskStatuses[task.Status]--
+	taskStatuses[status]++
+	task.Status = status
+}
+
+// takeTask leases out the task that has been ready the longest to the given agent.
+// It returns nil if no task is ready or the orchestrator is shutting down, since results
+// of tasks handed out then could no longer be recorded. Caller must hold storeMutex.
+func takeTask(now time.Time, agentID string) *Task {
+	if shuttingDown.Load() {
+		return nil
+	}
+	for {
+		entry, ok := readyQueue.pop()
+		if !ok {
+			return nil
+		}
+		// Tasks cancelled or deleted after they were queued are skipped here.
+		task, exists := tasksStore[entry.id]
+		if !exists || task.Status != "pending" || !updateTaskDependencies(task) {
+			continue
+		}
+		grantLease(task, now, agentID)
+		taskDispatchLatency.Observe(now.Sub(entry.queued).Seconds())
+		traceDispatch(task, entry.queued, now)
+		logger.Debug("task dispatched", "task_id", task.ID, "expression_id", task.ExpressionID,
+			"agent_id", agentID, "attempt", task.Attempts)
+		return task
+	}
+}
+
+// assignment is the part of a task sent to the agent computing it.
+type assignment struct {
+	ID            string    `json:"id"`
+	ExpressionID  string    `json:"expression_id"`
+	LeaseID       string    `json:"lease_id"`
+	Args          []float64 `json:"args"`
+	Operation     string    `json:"operation"`
+	OperationTime int       `json:"operation_time"`
+	traceParent   string    // sent in the traceparent header
+}
+
+// waitForTask leases out a ready task to the agent, waiting up to wait for one to become ready.
+// It returns nil if no task became ready in time or ctx was cancelled.
+func waitForTask(ctx context.Context, agentID string, wait time.Duration) *assignment {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		storeMutex.Lock()
+		var a *assignment
+		if task := takeTask(time.Now(), agentID); task != nil {
+			a = newAssignment(task)
+		}
+		ready := taskReady
+		storeMutex.Unlock()
+
+		if a != nil || wait == 0 {
+			return a
+		}
+		select {
+		case <-ready:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// newAssignment copies the task into an assignment. Caller must hold storeMutex.
+func newAssignment(task *Task) *assignment {
+	args := make([]float64, len(task.Args))
+	for i, arg := range task.Args {
+		args[i] = *arg
+	}
+	return &assignment{
+		ID:            task.ID,
+		ExpressionID:  task.ExpressionID,
+		LeaseID:       task.LeaseID,
+		Args:          args,
+		Operation:     task.Operator,
+		OperationTime: task.OperationTime,
+		traceParent:   task.TraceParent,
+	}
+}
+
+var (
+	errTaskNotFound   = errors.New("task not found")
+	errTaskCancelled  = errors.New("task cancelled")
+	errTaskNotRunning = errors.New("task not in running state")
+	errStaleLease     = errors.New("stale lease")
+)
+
+// leasedTask returns the running task if leaseID is its current lease. Caller must hold storeMutex.
+func leasedTask(id, leaseID string) (*Task, error) {
+	task, ok := tasksStore[id]
+	if !ok {
+		return nil, errTaskNotFound
+	}
+	if task.Status == "cancelled" {
+		return nil, errTaskCancelled
+	}
+	if task.Status != "running" {
+		return nil, errTaskNotRunning
+	}
+	if task.LeaseID != leaseID {
+		return nil, errStaleLease
+	}
+	return task, nil
+}
+
+// submitResult records the result reported by the agent holding the task's lease.
+// A non-empty reason reports a computation error instead, which fails the whole expression.
+// It returns the status to report back to the agent.
+func submitResult(id, leaseID string, result float64, reason string) (string, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	task, err := leasedTask(id, leaseID)
+	if err != nil {
+		return "", err
+	}
+	if reason != "" {
+		logger.Warn("task failed", "task_id", task.ID, "expression_id", task.ExpressionID, "error", reason)
+		setTaskStatus(task, "error")
+		task.Error = reason
+		saveTask(task)
+		failExpression(task.ExpressionID, reason)
+		return "error recorded", nil
+	}
+	if agent, ok := agentsStore[task.AgentID]; ok {
+		agent.Completed++
+	}
+	taskComputeTime.WithLabelValues(task.Operator).Observe(time.Since(task.LeasedAt).Seconds())
+	logger.Debug("task completed", "task_id", task.ID, "expression_id", task.ExpressionID, "result", result)
+	completeTask(task, result)
+	return "result recorded", nil
+}
+
+// completeTask records the result of the task, queues dependents that have become ready
+// and finishes the expression if this was its root task. Caller must hold storeMutex.
+func completeTask(task *Task, result float64) {
+	setTaskStatus(task, "done")
+	task.Result = &result
+	saveTask(task)
+	if count, ok := taskCounts[task.ExpressionID]; ok {
+		count.completed++
+	}
+	for _, id := range dependents[task.ID] {
+		if dep, ok := tasksStore[id]; ok && dep.Status == "pending" && updateTaskDependencies(dep) {
+			readyQueue.push(id)
+		}
+	}
+	delete(dependents, task.ID)
+	notifyTaskReady()
+	publishTaskDone(task)
+	// If this is the root task, update the expression status
+	expr, exists := expressionsStore[task.ExpressionID]
+	if exists && expr.RootTaskID == task.ID {
+		setExpressionStatus(expr, "done")
+		expr.Result = &result
+		expressionEnded(expr)
+	}
+}
+
+// rebuildScheduler recreates the dependency edges and the ready queue from tasksStore,
+// e.g. after loading it from the storage. Caller must hold storeMutex.
+func rebuildScheduler() {
+	readyQueue = taskQueue{}
+	dependents = make(map[string][]string)
+	taskCounts = make(map[string]*taskCount)
+	expressionTasks = make(map[string][]string)
+	taskStatuses = make(map[string]int)
+	leaseQueue = leaseHeap{}
+	agentTasks = make(map[string]map[string]bool)
+	for _, task := range tasksStore {
+		indexTask(task)
+		addDependent(task)
+		if task.Status == "pending" && updateTaskDependencies(task) {
+			readyQueue.push(task.ID)
+		}
+	}
+}
+
+// addDependent records the task as a dependent of each unfinished task it waits for.
+// A task using the same result twice (e.g. the product in (a+b)*(a+b)) is recorded once.
+// Caller must hold storeMutex.
+func addDependent(task *Task) {
+	for _, dep := range task.DepTasks {
+		if depTask, ok := tasksStore[dep]; ok && depTask.Status != "done" && !slices.Contains(dependents[dep], task.ID) {
+			dependents[dep] = append(dependents[dep], task.ID)
+		}
+	}
+}
+
+// notifyTaskReady wakes up all agents waiting for a task. Caller must hold storeMutex.
+func notifyTaskReady() {
+	close(taskReady)
+	taskReady = make(chan struct{})
+}
@@ -0,0 +1,147 @@
+package orchestrator
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func resetScheduler() {
+	expressionsStore = make(map[string]*Expression)
+	tasksStore = make(map[string]*Task)
+	rebuildScheduler()
+	rebuildExpressionIndex()
+}
+
+func TestTakeTaskFIFO(t *testing.T) {
+	resetScheduler()
+	first, err := BuildExpressionTasks("(1+2)*3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	second, err := BuildExpressionTasks("4-5")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	sum := takeTask(time.Now(), "")
+	if sum == nil || sum.ExpressionID != first.ID || sum.Operator != "+" {
+		t.Fatalf("expected the ready task of the first expression, got %+v", sum)
+	}
+	sub := takeTask(time.Now(), "")
+	if sub == nil || sub.ID != second.RootTaskID {
+		t.Fatalf("expected the task of the second expression, got %+v", sub)
+	}
+	if task := takeTask(time.Now(), ""); task != nil {
+		t.Fatalf("expected no ready tasks while dependencies are running, got %+v", task)
+	}
+
+	completeTask(sum, 3)
+	mul := takeTask(time.Now(), "")
+	if mul == nil || mul.ID != first.RootTaskID || *mul.Args[0] != 3 {
+		t.Fatalf("expected the dependent task to become ready with its argument, got %+v", mul)
+	}
+	completeTask(mul, 9)
+	if expr := expressionsStore[first.ID]; expr.Status != "done" || *expr.Result != 9 {
+		t.Errorf("expected expression to be done, got %+v", expr)
+	}
+}
+
+func TestTakeTaskSkipsCancelled(t *testing.T) {
+	resetScheduler()
+	expr, err := BuildExpressionTasks("1+2")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	cancelTasks(expr.ID)
+	if task := takeTask(time.Now(), ""); task != nil {
+		t.Errorf("expected cancelled task to be skipped, got %+v", task)
+	}
+}
+
+func TestBuildExpressionTasksSharesSubexpressions(t *testing.T) {
+	resetScheduler()
+	expr, err := BuildExpressionTasks("(1+2)*(1+2) - (1+2)*3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if len(tasksStore) != 4 {
+		t.Fatalf("expected 4 tasks for the distinct subexpressions, got %d", len(tasksStore))
+	}
+	sum := takeTask(time.Now(), "")
+	if sum == nil || sum.Operator != "+" {
+		t.Fatalf("expected the shared sum to be ready, got %+v", sum)
+	}
+	if task := takeTask(time.Now(), ""); task != nil {
+		t.Fatalf("expected no other ready tasks, got %+v", task)
+	}
+	if got := dependents[sum.ID]; len(got) != 2 {
+		t.Fatalf("expected the sum to have 2 dependents, got %v", got)
+	}
+
+	completeTask(sum, 3)
+	if count := *taskCounts[expr.ID]; count != (taskCount{total: 4, completed: 1}) {
+		t.Fatalf("expected 1 of 4 tasks to be completed, got %+v", count)
+	}
+	rebuildScheduler()
+	if count := *taskCounts[expr.ID]; count != (taskCount{total: 4, completed: 1}) {
+		t.Fatalf("expected the task counts to be rebuilt, got %+v", count)
+	}
+	for range 2 {
+		mul := takeTask(time.Now(), "")
+		if mul == nil || mul.Operator != "*" || *mul.Args[0] != 3 {
+			t.Fatalf("expected a product of the shared sum to become ready, got %+v", mul)
+		}
+		completeTask(mul, *mul.Args[0]**mul.Args[1])
+	}
+	sub := takeTask(time.Now(), "")
+	if sub == nil || sub.ID != expr.RootTaskID {
+		t.Fatalf("expected the root task to become ready, got %+v", sub)
+	}
+	if task := takeTask(time.Now(), ""); task != nil {
+		t.Fatalf("expected the root task to be queued once, got %+v", task)
+	}
+	completeTask(sub, *sub.Args[0]-*sub.Args[1])
+	if expr := expressionsStore[expr.ID]; expr.Status != "done" || *expr.Result != 0 {
+		t.Errorf("expected expression to be done with result 0, got %+v", expr)
+	}
+}
+
+// BenchmarkTakeTask measures dispatching and completing tasks with 100k tasks in the ready queue.
+func BenchmarkTakeTask(b *testing.B) {
+	resetScheduler()
+	one := 1.0
+	newTask := func(i int) *Task {
+		return &Task{
+			ID:           fmt.Sprintf("task-%d", i),
+			ExpressionID: fmt.Sprintf("expr-%d", i),
+			Operator:     "+",
+			Args:         []*float64{&one, &one},
+			DepTasks:     make([]string, 2),
+			Status:       "pending",
+		}
+	}
+	const queued = 100_000
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	for i := 0; i < queued; i++ {
+		addTask(newTask(i))
+	}
+	now := time.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		task := takeTask(now, "")
+		completeTask(task, 2)
+		// Keep the queue at the same length
+		addTask(newTask(queued + i))
+	}
+}
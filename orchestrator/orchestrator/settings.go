@@ -8,10 +8,43 @@ import (
 // Settings holds all the configuration values
 var (
 	Port                 = getEnv("ORCHESTRATOR_PORT", "8080")
+	GRPCPort             = getEnv("GRPC_PORT", "") // empty disables the gRPC server
 	AdditionTimeMs       = getEnvInt("TIME_ADDITION_MS", 1000)
 	SubtractionTimeMs    = getEnvInt("TIME_SUBTRACTION_MS", 1000)
 	MultiplicationTimeMs = getEnvInt("TIME_MULTIPLICATIONS_MS", 1000)
 	DivisionTimeMs       = getEnvInt("TIME_DIVISIONS_MS", 1000)
+	NegationTimeMs       = getEnvInt("TIME_NEGATION_MS", 1000)
+	PowerTimeMs          = getEnvInt("TIME_POWER_MS", 1000)
+	FunctionTimeMs       = getEnvInt("TIME_FUNCTIONS_MS", 1000)
+	LeaseSlackMs         = getEnvInt("TASK_LEASE_SLACK_MS", 5000)
+	LeaseCheckIntervalMs = getEnvInt("TASK_LEASE_CHECK_INTERVAL_MS", 1000)
+	MaxTaskAttempts      = getEnvInt("TASK_MAX_ATTEMPTS", 3)
+	MaxTaskWaitMs        = getEnvInt("TASK_MAX_WAIT_MS", 60000)
+	AgentTimeoutMs       = getEnvInt("AGENT_TIMEOUT_MS", 15000)
+	StoragePath          = getEnv("STORAGE_PATH", "")
+	StorageSnapshotEvery = getEnvInt("STORAGE_SNAPSHOT_EVERY", 1000)
+	MaxBatchSize         = getEnvInt("BATCH_MAX_SIZE", 10000)
+	IdempotencyKeyTTLMs  = getEnvInt("IDEMPOTENCY_KEY_TTL_MS", 24*60*60*1000)
+	WebhookSecret        = getEnv("WEBHOOK_SECRET", "")
+	WebhookMaxAttempts   = getEnvInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	WebhookBackoffMs     = getEnvInt("WEBHOOK_BACKOFF_MS", 1000)
+	WebhookTimeoutMs     = getEnvInt("WEBHOOK_TIMEOUT_MS", 5000)
+	JWTSecret            = getEnv("JWT_SECRET", "") // empty signs tokens with a random key
+	JWTTTLMs             = getEnvInt("JWT_TTL_MS", 24*60*60*1000)
+	ShutdownTimeoutMs    = getEnvInt("SHUTDOWN_TIMEOUT_MS", 5000)
+	LogLevel             = getEnv("LOG_LEVEL", "info")  // "debug", "info", "warn" or "error"
+	LogFormat            = getEnv("LOG_FORMAT", "text") // "text" or "json"
+	// OTLPEndpoint is the OTLP/HTTP endpoint of the collector receiving traces; empty disables exporting them.
+	OTLPEndpoint = getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	ServiceName  = getEnv("OTEL_SERVICE_NAME", "orchestrator")
+	// WebhookAllowedNetworks are comma-separated CIDR ranges of non-public networks that callback URLs
+	// may point to, e.g. "10.0.0.0/8"; by default webhooks are only sent to public addresses.
+	WebhookAllowedNetworks = getEnv("WEBHOOK_ALLOWED_NETWORKS", "")
+	// InternalPort is the port of the listener serving agents: the /internal endpoints and GET /api/v1/agents.
+	InternalPort = getEnv("INTERNAL_PORT", "8081")
+	// WebSocketAllowedOrigins are comma-separated host patterns, e.g. "app.example.com" or "*.example.com",
+	// of pages on other origins allowed to open /api/v1/ws; by default only same-origin pages are.
+	WebSocketAllowedOrigins = getEnv("WS_ALLOWED_ORIGINS", "")
 )
 
 // getEnv retrieves a string environment variable or returns a default value.
@@ -0,0 +1,72 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// shuttingDown is set once the orchestrator starts shutting down. From then on it refuses
+// new expressions and hands out no tasks, while requests in flight are drained.
+var shuttingDown atomic.Bool
+
+// errShuttingDown is the message of requests refused while shutting down.
+const errShuttingDown = "orchestrator is shutting down"
+
+// refuseWhileShuttingDown answers 503 Service Unavailable once the orchestrator is shutting down,
+// so that the client retries with another orchestrator, and reports whether it did.
+func refuseWhileShuttingDown(w http.ResponseWriter) bool {
+	if !shuttingDown.Load() {
+		return false
+	}
+	w.Header().Set("Connection", "close")
+	http.Error(w, errShuttingDown, http.StatusServiceUnavailable)
+	return true
+}
+
+// shutdown stops the servers gracefully: new expressions are refused, long polls and event
+// streams are ended by cancelling the request contexts, and requests in flight get up to
+// ShutdownTimeoutMs to finish. Afterwards the storage is flushed and closed and the remaining
+// spans are exported.
+func shutdown(servers []*http.Server, grpcServer *grpc.Server, cancelRequests context.CancelFunc) {
+	shuttingDown.Store(true)
+	cancelRequests()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(ShutdownTimeoutMs)*time.Millisecond)
+	defer cancel()
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Warn("draining requests", "addr", server.Addr, "error", err)
+			server.Close()
+		}
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			logger.Warn("draining gRPC calls", "error", ctx.Err())
+			grpcServer.Stop()
+		}
+	}
+
+	storeMutex.Lock()
+	if err := storage.Close(); err != nil {
+		logger.Error("closing storage", "error", err)
+	}
+	// Changes made from now on, e.g. by webhook deliveries finishing, are not persisted.
+	// Deliveries still pending in the storage are resumed after a restart.
+	storage = memoryStorage{}
+	storeMutex.Unlock()
+
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		logger.Warn("exporting spans", "error", err)
+	}
+	logger.Info("orchestrator stopped")
+}
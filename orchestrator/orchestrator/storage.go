@@ -0,0 +1,531 @@
+package orchestrator
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Storage persists expressions, tasks, users and idempotency keys so that they survive an orchestrator
+// restart. expressionsStore, tasksStore, usersStore and idempotencyKeys remain the working set; every
+// change to them is written through to the storage, and the storage is loaded back into them on startup.
+type Storage interface {
+	// Load returns all expressions and tasks saved so far.
+	Load() (map[string]*Expression, map[string]*Task, error)
+	// SaveExpression stores the current state of the expression.
+	SaveExpression(expr *Expression) error
+	// SaveTask stores the current state of the task.
+	SaveTask(task *Task) error
+	// DeleteExpression removes the expression.
+	DeleteExpression(id string) error
+	// DeleteTask removes the task.
+	DeleteTask(id string) error
+	// LoadUsers returns all users saved so far. It is called after Load.
+	LoadUsers() (map[string]*User, error)
+	// SaveUser stores the current state of the user.
+	SaveUser(user *User) error
+	// LoadIdempotencyKeys returns all idempotency keys saved so far, by key. It is called after Load.
+	LoadIdempotencyKeys() (map[string]*idempotencyRecord, error)
+	// SaveIdempotencyKey stores the expression created for an idempotency key.
+	SaveIdempotencyKey(rec *idempotencyRecord) error
+	// DeleteIdempotencyKey removes the idempotency key.
+	DeleteIdempotencyKey(key string) error
+	// Close flushes pending writes and releases the storage.
+	Close() error
+}
+
+// storage is the storage used by the orchestrator. Access is guarded by storeMutex.
+var storage Storage = memoryStorage{}
+
+// saveExpression writes the expression through to the storage. Caller must hold storeMutex.
+func saveExpression(expr *Expression) {
+	if err := storage.SaveExpression(expr); err != nil {
+		logger.Error("saving expression", "expression_id", expr.ID, "error", err)
+	}
+}
+
+// saveTask writes the task through to the storage. Caller must hold storeMutex.
+func saveTask(task *Task) {
+	if err := storage.SaveTask(task); err != nil {
+		logger.Error("saving task", "task_id", task.ID, "expression_id", task.ExpressionID, "error", err)
+	}
+}
+
+// saveUser writes the user through to the storage. Caller must hold storeMutex.
+func saveUser(user *User) {
+	if err := storage.SaveUser(user); err != nil {
+		logger.Error("saving user", "user_id", user.ID, "error", err)
+	}
+}
+
+// removeExpression deletes the expression from the storage. Caller must hold storeMutex.
+func removeExpression(id string) {
+	if err := storage.DeleteExpression(id); err != nil {
+		logger.Error("deleting expression", "expression_id", id, "error", err)
+	}
+}
+
+// removeTask deletes the task from the storage. Caller must hold storeMutex.
+func removeTask(id string) {
+	if err := storage.DeleteTask(id); err != nil {
+		logger.Error("deleting task", "task_id", id, "error", err)
+	}
+}
+
+// openStorage opens the storage configured by STORAGE_PATH and loads its contents
+// into expressionsStore, tasksStore, usersStore and idempotencyKeys. An empty path keeps everything in memory.
+func openStorage() error {
+	var s Storage = memoryStorage{}
+	if StoragePath != "" {
+		fs, err := NewFileStorage(StoragePath, StorageSnapshotEvery)
+		if err != nil {
+			return err
+		}
+		s = fs
+	}
+	exprs, tasks, err := s.Load()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	users, err := s.LoadUsers()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	keys, err := s.LoadIdempotencyKeys()
+	if err != nil {
+		s.Close()
+		return err
+	}
+	storeMutex.Lock()
+	storage = s
+	expressionsStore = exprs
+	tasksStore = tasks
+	usersStore = users
+	idempotencyKeys = keys
+	expireIdempotencyKeys(time.Now())
+	rebuildScheduler()
+	rebuildExpressionIndex()
+	resumeWebhooks()
+	storeMutex.Unlock()
+	return nil
+}
+
+// memoryStorage keeps state only in the in-memory stores; nothing survives a restart.
+type memoryStorage struct{}
+
+func (memoryStorage) Load() (map[string]*Expression, map[string]*Task, error) {
+	return make(map[string]*Expression), make(map[string]*Task), nil
+}
+
+func (memoryStorage) SaveExpression(*Expression) error { return nil }
+
+func (memoryStorage) SaveTask(*Task) error { return nil }
+
+func (memoryStorage) DeleteExpression(string) error { return nil }
+
+func (memoryStorage) DeleteTask(string) error { return nil }
+
+func (memoryStorage) LoadUsers() (map[string]*User, error) { return make(map[string]*User), nil }
+
+func (memoryStorage) SaveUser(*User) error { return nil }
+
+func (memoryStorage) LoadIdempotencyKeys() (map[string]*idempotencyRecord, error) {
+	return make(map[string]*idempotencyRecord), nil
+}
+
+func (memoryStorage) SaveIdempotencyKey(*idempotencyRecord) error { return nil }
+
+func (memoryStorage) DeleteIdempotencyKey(string) error { return nil }
+
+func (memoryStorage) Close() error { return nil }
+
+const (
+	snapshotFileName = "snapshot.json"
+	walFileName      = "wal.jsonl"
+)
+
+// expressionRecord is the persisted form of an Expression, including fields hidden from the API.
+type expressionRecord struct {
+	*Expression
+	RootTaskID        string            `json:"root_task_id"`
+	TraceParent       string            `json:"trace_parent,omitempty"`
+	Owner             string            `json:"owner,omitempty"`
+	WebhookStatus     string            `json:"webhook_status,omitempty"`
+	WebhookDeliveries []WebhookDelivery `json:"webhook_deliveries,omitempty"`
+}
+
+// walRecord is a single line of the write-ahead log.
+type walRecord struct {
+	// "expression", "task", "user", "idempotency_key", "delete_expression", "delete_task" or "delete_idempotency_key"
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+// snapshot is the content of the snapshot file.
+type snapshot struct {
+	Expressions     []json.RawMessage `json:"expressions"`
+	Tasks           []json.RawMessage `json:"tasks"`
+	Users           []json.RawMessage `json:"users,omitempty"`
+	IdempotencyKeys []json.RawMessage `json:"idempotency_keys,omitempty"`
+}
+
+// FileStorage stores state in a directory as a snapshot plus an append-only write-ahead log.
+// Every change is appended to the log; once the log grows past snapshotEvery records,
+// the full state is written to a new snapshot and the log is truncated.
+//
+// Appends are written to the log right away but synced to disk by a separate goroutine,
+// so that callers holding storeMutex don't wait for the disk. All records appended while
+// a sync is in progress are committed together by the next one.
+type FileStorage struct {
+	dir           string
+	snapshotEvery int
+	wal           *os.File
+	walRecords    int
+	// syncRequests has a pending request if records were appended since the last sync started.
+	syncRequests chan struct{}
+	// syncDone is closed when the syncing goroutine stops.
+	syncDone chan struct{}
+	// Last saved encoding of each object, used to write snapshots.
+	expressions map[string]json.RawMessage
+	tasks       map[string]json.RawMessage
+	users       map[string]json.RawMessage
+	keys        map[string]json.RawMessage
+}
+
+// NewFileStorage opens (or creates) a file storage in the given directory.
+func NewFileStorage(dir string, snapshotEvery int) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	wal, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStorage{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		wal:           wal,
+		syncRequests:  make(chan struct{}, 1),
+		syncDone:      make(chan struct{}),
+		expressions:   make(map[string]json.RawMessage),
+		tasks:         make(map[string]json.RawMessage),
+		users:         make(map[string]json.RawMessage),
+		keys:          make(map[string]json.RawMessage),
+	}
+	go s.syncLog()
+	return s, nil
+}
+
+// syncLog syncs the log to disk whenever records have been appended, until Close.
+func (s *FileStorage) syncLog() {
+	defer close(s.syncDone)
+	for range s.syncRequests {
+		if err := s.wal.Sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+			logger.Error("syncing write-ahead log", "error", err)
+		}
+	}
+}
+
+// Load reads the snapshot and replays the write-ahead log on top of it.
+func (s *FileStorage) Load() (map[string]*Expression, map[string]*Task, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	if err == nil {
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			return nil, nil, fmt.Errorf("reading snapshot: %w", err)
+		}
+		for _, raw := range snap.Expressions {
+			if err := s.apply(walRecord{Kind: "expression", Data: raw}); err != nil {
+				return nil, nil, fmt.Errorf("reading snapshot: %w", err)
+			}
+		}
+		for _, raw := range snap.Tasks {
+			if err := s.apply(walRecord{Kind: "task", Data: raw}); err != nil {
+				return nil, nil, fmt.Errorf("reading snapshot: %w", err)
+			}
+		}
+		for _, raw := range snap.Users {
+			if err := s.apply(walRecord{Kind: "user", Data: raw}); err != nil {
+				return nil, nil, fmt.Errorf("reading snapshot: %w", err)
+			}
+		}
+		for _, raw := range snap.IdempotencyKeys {
+			if err := s.apply(walRecord{Kind: "idempotency_key", Data: raw}); err != nil {
+				return nil, nil, fmt.Errorf("reading snapshot: %w", err)
+			}
+		}
+	}
+
+	if _, err := s.wal.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(s.wal)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				// The orchestrator stopped in the middle of a write; drop the torn record.
+				logger.Warn("ignoring incomplete record at the end of the write-ahead log")
+			}
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, nil, fmt.Errorf("reading write-ahead log: %w", err)
+		}
+		if err := s.apply(rec); err != nil {
+			return nil, nil, fmt.Errorf("reading write-ahead log: %w", err)
+		}
+		s.walRecords++
+	}
+
+	exprs := make(map[string]*Expression, len(s.expressions))
+	for id, raw := range s.expressions {
+		rec := expressionRecord{Expression: &Expression{}}
+		if err := json.Unmarshal(raw, &rec); err != nil {
+			return nil, nil, err
+		}
+		rec.Expression.RootTaskID = rec.RootTaskID
+		rec.Expression.TraceParent = rec.TraceParent
+		rec.Expression.Owner = rec.Owner
+		rec.Expression.WebhookStatus = rec.WebhookStatus
+		rec.Expression.WebhookDeliveries = rec.WebhookDeliveries
+		exprs[id] = rec.Expression
+	}
+	tasks := make(map[string]*Task, len(s.tasks))
+	for id, raw := range s.tasks {
+		task := &Task{}
+		if err := json.Unmarshal(raw, task); err != nil {
+			return nil, nil, err
+		}
+		tasks[id] = task
+	}
+	// Start from a clean log so that a torn record is not followed by new ones.
+	if err := s.writeSnapshot(); err != nil {
+		return nil, nil, err
+	}
+	return exprs, tasks, nil
+}
+
+// apply records the object from a log record as the latest saved state.
+func (s *FileStorage) apply(rec walRecord) error {
+	var obj struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(rec.Data, &obj); err != nil {
+		return err
+	}
+	switch rec.Kind {
+	case "expression":
+		s.expressions[obj.ID] = rec.Data
+	case "task":
+		s.tasks[obj.ID] = rec.Data
+	case "user":
+		s.users[obj.ID] = rec.Data
+	case "idempotency_key":
+		s.keys[obj.ID] = rec.Data
+	case "delete_expression":
+		delete(s.expressions, obj.ID)
+	case "delete_task":
+		delete(s.tasks, obj.ID)
+	case "delete_idempotency_key":
+		delete(s.keys, obj.ID)
+	default:
+		return fmt.Errorf("unknown record kind %q", rec.Kind)
+	}
+	return nil
+}
+
+// SaveExpression appends the expression to the write-ahead log.
+func (s *FileStorage) SaveExpression(expr *Expression) error {
+	data, err := json.Marshal(expressionRecord{
+		Expression:        expr,
+		RootTaskID:        expr.RootTaskID,
+		TraceParent:       expr.TraceParent,
+		Owner:             expr.Owner,
+		WebhookStatus:     expr.WebhookStatus,
+		WebhookDeliveries: expr.WebhookDeliveries,
+	})
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "expression", Data: data})
+}
+
+// LoadUsers decodes the users read by Load.
+func (s *FileStorage) LoadUsers() (map[string]*User, error) {
+	users := make(map[string]*User, len(s.users))
+	for _, raw := range s.users {
+		user := &User{}
+		if err := json.Unmarshal(raw, user); err != nil {
+			return nil, err
+		}
+		users[user.Login] = user
+	}
+	return users, nil
+}
+
+// SaveUser appends the user to the write-ahead log.
+func (s *FileStorage) SaveUser(user *User) error {
+	data, err := json.Marshal(user)
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "user", Data: data})
+}
+
+// LoadIdempotencyKeys decodes the idempotency keys read by Load.
+func (s *FileStorage) LoadIdempotencyKeys() (map[string]*idempotencyRecord, error) {
+	keys := make(map[string]*idempotencyRecord, len(s.keys))
+	for _, raw := range s.keys {
+		rec := &idempotencyRecord{}
+		if err := json.Unmarshal(raw, rec); err != nil {
+			return nil, err
+		}
+		keys[rec.Key] = rec
+	}
+	return keys, nil
+}
+
+// SaveIdempotencyKey appends the idempotency key to the write-ahead log.
+func (s *FileStorage) SaveIdempotencyKey(rec *idempotencyRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "idempotency_key", Data: data})
+}
+
+// DeleteIdempotencyKey appends the removal of the idempotency key to the write-ahead log.
+func (s *FileStorage) DeleteIdempotencyKey(key string) error {
+	data, err := json.Marshal(map[string]string{"id": key})
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "delete_idempotency_key", Data: data})
+}
+
+// SaveTask appends the task to the write-ahead log.
+func (s *FileStorage) SaveTask(task *Task) error {
+	data, err := json.Marshal(task)
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "task", Data: data})
+}
+
+// DeleteExpression appends the removal of the expression to the write-ahead log.
+func (s *FileStorage) DeleteExpression(id string) error {
+	data, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "delete_expression", Data: data})
+}
+
+// DeleteTask appends the removal of the task to the write-ahead log.
+func (s *FileStorage) DeleteTask(id string) error {
+	data, err := json.Marshal(map[string]string{"id": id})
+	if err != nil {
+		return err
+	}
+	return s.append(walRecord{Kind: "delete_task", Data: data})
+}
+
+// append writes the record to the log and takes a snapshot when the log is long enough.
+func (s *FileStorage) append(rec walRecord) error {
+	if err := s.apply(rec); err != nil {
+		return err
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.walRecords++
+	if s.walRecords >= s.snapshotEvery {
+		return s.writeSnapshot()
+	}
+	select {
+	case s.syncRequests <- struct{}{}:
+	default:
+		// A sync that hasn't started yet covers this record too
+	}
+	return nil
+}
+
+// writeSnapshot atomically replaces the snapshot with the current state and truncates the log.
+func (s *FileStorage) writeSnapshot() error {
+	snap := snapshot{
+		Expressions:     make([]json.RawMessage, 0, len(s.expressions)),
+		Tasks:           make([]json.RawMessage, 0, len(s.tasks)),
+		Users:           make([]json.RawMessage, 0, len(s.users)),
+		IdempotencyKeys: make([]json.RawMessage, 0, len(s.keys)),
+	}
+	for _, raw := range s.expressions {
+		snap.Expressions = append(snap.Expressions, raw)
+	}
+	for _, raw := range s.tasks {
+		snap.Tasks = append(snap.Tasks, raw)
+	}
+	for _, raw := range s.users {
+		snap.Users = append(snap.Users, raw)
+	}
+	for _, raw := range s.keys {
+		snap.IdempotencyKeys = append(snap.IdempotencyKeys, raw)
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(s.dir, snapshotFileName+".tmp")
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFileName)); err != nil {
+		return err
+	}
+	if err := s.wal.Truncate(0); err != nil {
+		return err
+	}
+	s.walRecords = 0
+	return nil
+}
+
+// Close waits for the pending sync, writes a final snapshot and closes the log.
+func (s *FileStorage) Close() error {
+	close(s.syncRequests)
+	<-s.syncDone
+	if err := s.writeSnapshot(); err != nil {
+		s.wal.Close()
+		return err
+	}
+	return s.wal.Close()
+}
@@ -0,0 +1,152 @@
+package orchestrator
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStorageRestoresState(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir, 4)
+	if err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	if _, _, err := s.Load(); err != nil {
+		t.Fatalf("failed to load empty storage: %v", err)
+	}
+
+	pending := &Expression{ID: "expr1", Expr: "2+2", Status: "pending", RootTaskID: "task1", Owner: "user1"}
+	done := &Expression{ID: "expr2", Expr: "3*3", Status: "done", Result: float64Ptr(9), RootTaskID: "task2"}
+	task1 := &Task{ID: "task1", ExpressionID: "expr1", Operator: "+", Args: []*float64{float64Ptr(2), float64Ptr(2)}, Status: "pending"}
+	task2 := &Task{ID: "task2", ExpressionID: "expr2", Operator: "*", Status: "running"}
+	user := &User{ID: "user1", Login: "alice", PasswordHash: "pbkdf2-sha256$1$c2FsdA$aGFzaA"}
+	key := &idempotencyRecord{Key: "user1:key1", RequestHash: "abcd", ExpressionID: "expr1", Expires: time.Unix(100, 0).UTC()}
+	expiredKey := &idempotencyRecord{Key: "user1:key2", RequestHash: "abcd", ExpressionID: "expr2", Expires: time.Unix(50, 0).UTC()}
+	// Enough writes to go through a snapshot and leave some records in the log.
+	for _, err := range []error{
+		s.SaveExpression(pending),
+		s.SaveExpression(done),
+		s.SaveTask(task1),
+		s.SaveTask(task2),
+		s.SaveUser(user),
+		s.SaveIdempotencyKey(key),
+		s.SaveIdempotencyKey(expiredKey),
+		s.DeleteIdempotencyKey(expiredKey.Key),
+	} {
+		if err != nil {
+			t.Fatalf("failed to save: %v", err)
+		}
+	}
+	task2.Status = "done"
+	task2.Result = float64Ptr(9)
+	if err := s.SaveTask(task2); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+	if err := s.wal.Close(); err != nil {
+		t.Fatalf("failed to close log: %v", err)
+	}
+
+	s, err = NewFileStorage(dir, 4)
+	if err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	defer s.Close()
+	exprs, tasks, err := s.Load()
+	if err != nil {
+		t.Fatalf("failed to load storage: %v", err)
+	}
+
+	users, err := s.LoadUsers()
+	if err != nil {
+		t.Fatalf("failed to load users: %v", err)
+	}
+	keys, err := s.LoadIdempotencyKeys()
+	if err != nil {
+		t.Fatalf("failed to load idempotency keys: %v", err)
+	}
+
+	if len(exprs) != 2 || len(tasks) != 2 {
+		t.Fatalf("expected 2 expressions and 2 tasks, got %d and %d", len(exprs), len(tasks))
+	}
+	if expr := exprs["expr1"]; expr.Status != "pending" || expr.RootTaskID != "task1" || expr.Owner != "user1" {
+		t.Errorf("pending expression not restored: %+v", expr)
+	}
+	if expr := exprs["expr2"]; expr.Status != "done" || expr.Result == nil || *expr.Result != 9 {
+		t.Errorf("completed expression not restored: %+v", expr)
+	}
+	if task := tasks["task1"]; task.Status != "pending" || len(task.Args) != 2 || *task.Args[0] != 2 {
+		t.Errorf("pending task not restored: %+v", task)
+	}
+	if task := tasks["task2"]; task.Status != "done" {
+		t.Errorf("expected latest task state to be restored, got %+v", task)
+	}
+	if got := users["alice"]; got == nil || *got != *user {
+		t.Errorf("user not restored: %+v", got)
+	}
+	if got := keys[key.Key]; len(keys) != 1 || got == nil || *got != *key {
+		t.Errorf("expected only the remaining idempotency key to be restored, got %+v", keys)
+	}
+}
+
+func TestOpenStorageRestart(t *testing.T) {
+	resetScheduler()
+	defer func(path string) { StoragePath = path }(StoragePath)
+	StoragePath = t.TempDir()
+	if err := openStorage(); err != nil {
+		t.Fatalf("failed to open storage: %v", err)
+	}
+	defer func() {
+		storage.Close()
+		storage = memoryStorage{}
+		resetScheduler()
+	}()
+	expr, err := BuildExpressionTasks("(1+2)*3")
+	if err != nil {
+		t.Fatalf("failed to build expression: %v", err)
+	}
+	storeMutex.Lock()
+	sum := takeTask(time.Now(), "")
+	completeTask(sum, 3)
+	crashed := storage.(*FileStorage)
+	storeMutex.Unlock()
+
+	// The orchestrator dies in the middle of appending a record, without closing the storage
+	wal, err := os.OpenFile(filepath.Join(StoragePath, walFileName), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	if _, err := wal.WriteString(`{"kind":"task","data":{"id":"torn","status":`); err != nil {
+		t.Fatalf("failed to write torn record: %v", err)
+	}
+	wal.Close()
+	crashed.wal.Close()
+
+	if err := openStorage(); err != nil {
+		t.Fatalf("failed to reopen storage: %v", err)
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if got := expressionsStore[expr.ID]; got == nil || got.Status != "pending" {
+		t.Fatalf("expected the pending expression to be restored, got %+v", got)
+	}
+	if len(tasksStore) != 2 || tasksStore["torn"] != nil {
+		t.Fatalf("expected the 2 tasks of the expression without the torn record, got %d", len(tasksStore))
+	}
+	if count := *taskCounts[expr.ID]; count != (taskCount{total: 2, completed: 1}) {
+		t.Errorf("expected 1 of 2 tasks to be completed, got %+v", count)
+	}
+	if info, err := os.Stat(filepath.Join(StoragePath, walFileName)); err != nil || info.Size() != 0 {
+		t.Errorf("expected the log to start over after loading, got %v, %v", info, err)
+	}
+	// The scheduler picks up where it left off
+	product := takeTask(time.Now(), "")
+	if product == nil || product.ID != expr.RootTaskID || *product.Args[0] != 3 {
+		t.Fatalf("expected the product to be ready with the restored sum, got %+v", product)
+	}
+	completeTask(product, 9)
+	if got := expressionsStore[expr.ID]; got.Status != "done" || *got.Result != 9 {
+		t.Errorf("expected the expression to be done with result 9, got %+v", got)
+	}
+}
@@ -1,11 +1,17 @@
 package orchestrator
 
 import (
+	"context"
 	"errors"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ZolotarevAlexandr/yl_sprint_2_final/calculator/calculator"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -16,34 +22,49 @@ var (
 
 // Expression represents an expression submitted by the user.
 type Expression struct {
-	ID         string   `json:"id"`
-	Expr       string   `json:"expression"`
-	Status     string   `json:"status"` // "pending" or "done"
-	Result     *float64 `json:"result,omitempty"`
-	RootTaskID string   `json:"-"`
+	ID          string     `json:"id"`
+	Expr        string     `json:"expression"`
+	Status      string     `json:"status"` // "pending", "done", "error" or "cancelled"
+	Result      *float64   `json:"result,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"` // when the expression was done, failed or cancelled
+	Owner       string     `json:"-"`                      // id of the user who submitted the expression
+	BatchID     string     `json:"batch_id,omitempty"`     // set for expressions submitted in a batch
+	CallbackURL string     `json:"callback_url,omitempty"` // receives the final state of the expression
+	RootTaskID  string     `json:"-"`
+	TraceParent string     `json:"-"` // span context of BuildExpressionTasks, the root of the expression's trace
+	// WebhookStatus is "pending", "delivered" or "failed" once the expression has ended.
+	WebhookStatus     string            `json:"-"`
+	WebhookDeliveries []WebhookDelivery `json:"-"`
 }
 
-// Task represents an individual task (binary operation).
+// Task represents an individual task (an operator or a function applied to its arguments).
+// Each argument is either known upfront or is the result of the dependency task at the same position.
 type Task struct {
 	ID            string `json:"id"`
 	ExpressionID  string
-	Operator      string   `json:"operation"`
-	Arg1          *float64 `json:"arg1,omitempty"`
-	Arg2          *float64 `json:"arg2,omitempty"`
-	DepTask1      string
-	DepTask2      string
-	OperationTime int      `json:"operation_time"` // (in milliseconds)
-	Status        string   // "pending", "running", "done"
-	Result        *float64 `json:"result,omitempty"`
+	Operator      string     `json:"operation"`
+	Args          []*float64 `json:"args,omitempty"`
+	DepTasks      []string   // "" for arguments that are literals
+	OperationTime int        `json:"operation_time"` // (in milliseconds)
+	Status        string     // "pending", "running", "done", "error" or "cancelled"
+	Result        *float64   `json:"result,omitempty"`
+	Error         string     `json:"error,omitempty"`
+	LeaseID       string     // identifies the lease of the agent currently holding the task
+	AgentID       string     // registered agent holding the task, if any
+	LeasedAt      time.Time  // when the task was last handed out
+	LeaseExpires  time.Time  // when the lease runs out and the task is re-queued
+	Attempts      int        // how many times the task has been handed out
+	TraceParent   string     `json:"-"` // span context of the last dispatch, sent to the agent
 }
 
 // Node represents a node in the expression tree.
 type Node struct {
 	IsLiteral bool
 	Value     float64 // if IsLiteral is true
-	Operator  string  // if node represents an operation
-	Left      *Node
-	Right     *Node
+	Operator  string  // if node represents an operation or a function call
+	Args      []*Node
 	TaskID    string
 }
 
@@ -58,7 +79,14 @@ func getOperationTime(op string) int {
 		return MultiplicationTimeMs
 	case "/":
 		return DivisionTimeMs
+	case "neg":
+		return NegationTimeMs
+	case "^":
+		return PowerTimeMs
 	default:
+		if calculator.IsFunction(op) {
+			return FunctionTimeMs
+		}
 		return 1000
 	}
 }
@@ -70,19 +98,26 @@ func buildExpressionTree(tokens []calculator.Token) (*Node, error) {
 		if token.IsOperand {
 			val, _ := token.GetOperand()
 			stack = append(stack, &Node{IsLiteral: true, Value: val})
-		} else if token.IsOperator {
-			if len(stack) < 2 {
+		} else if token.IsOperator || token.IsFunction {
+			arity := token.Arity()
+			if len(stack) < arity {
 				return nil, errors.New("not enough operands")
 			}
-			right := stack[len(stack)-1]
-			left := stack[len(stack)-2]
-			stack = stack[:len(stack)-2]
+			if token.IsUnary && token.Value.(string) == "pos" {
+				// Unary plus does not change its operand, so no task is needed for it.
+				continue
+			}
+			if operand := stack[len(stack)-1]; token.IsUnary && operand.IsLiteral {
+				// Negating a number is folded into the literal instead of costing a task.
+				operand.Value = -operand.Value
+				continue
+			}
 			node := &Node{
 				IsLiteral: false,
 				Operator:  token.Value.(string),
-				Left:      left,
-				Right:     right,
+				Args:      append([]*Node(nil), stack[len(stack)-arity:]...),
 			}
+			stack = stack[:len(stack)-arity]
 			stack = append(stack, node)
 		} else {
 			return nil, errors.New("unexpected token")
@@ -94,47 +129,86 @@ func buildExpressionTree(tokens []calculator.Token) (*Node, error) {
 	return stack[0], nil
 }
 
+// taskBuilder collects the tasks of an expression before they are stored.
+type taskBuilder struct {
+	exprID string
+	tasks  []*Task // dependencies come before the tasks waiting for them
+	shared map[string]string
+}
+
 // createTasksFromNode recursively creates tasks from the expression tree.
 // If the node represents an operation, a task is generated and its identifier is returned.
-func createTasksFromNode(exprID string, node *Node) string {
+// Structurally equal subtrees share a single task with several dependents, so the tasks
+// of an expression form a DAG: shared maps the subtreeKey of every task created so far to its id.
+func (b *taskBuilder) createTasksFromNode(node *Node) string {
 	if node.IsLiteral {
 		return ""
 	}
-	var dep1, dep2 string
-	if !node.Left.IsLiteral {
-		dep1 = createTasksFromNode(exprID, node.Left)
-	}
-	if !node.Right.IsLiteral {
-		dep2 = createTasksFromNode(exprID, node.Right)
-	}
-	var arg1, arg2 *float64
-	if node.Left.IsLiteral {
-		arg1 = &node.Left.Value
+	args := make([]*float64, len(node.Args))
+	deps := make([]string, len(node.Args))
+	for i, arg := range node.Args {
+		if arg.IsLiteral {
+			args[i] = &arg.Value
+		} else {
+			deps[i] = b.createTasksFromNode(arg)
+		}
 	}
-	if node.Right.IsLiteral {
-		arg2 = &node.Right.Value
+	key := subtreeKey(node.Operator, args, deps)
+	if id, ok := b.shared[key]; ok {
+		node.TaskID = id
+		return id
 	}
 	task := &Task{
 		ID:            uuid.New().String(),
-		ExpressionID:  exprID,
+		ExpressionID:  b.exprID,
 		Operator:      node.Operator,
-		Arg1:          arg1,
-		Arg2:          arg2,
-		DepTask1:      dep1,
-		DepTask2:      dep2,
+		Args:          args,
+		DepTasks:      deps,
 		OperationTime: getOperationTime(node.Operator),
 		Status:        "pending",
 	}
 	node.TaskID = task.ID
-	storeMutex.Lock()
-	tasksStore[task.ID] = task
-	storeMutex.Unlock()
+	b.shared[key] = task.ID
+	b.tasks = append(b.tasks, task)
 	return task.ID
 }
 
+// subtreeKey identifies the subtree of an operation by its operator and its arguments: literal values
+// or the ids of the tasks computing them. As equal subtrees below have already been given the same task,
+// two subtrees get the same key exactly when they are structurally equal.
+func subtreeKey(operator string, args []*float64, deps []string) string {
+	var b strings.Builder
+	b.WriteString(operator)
+	for i, dep := range deps {
+		if dep != "" {
+			b.WriteString(" $")
+			b.WriteString(dep)
+		} else {
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(*args[i], 'g', -1, 64))
+		}
+	}
+	return b.String()
+}
+
 // BuildExpressionTasks accepts an expression string, builds the tree, and generates tasks.
 func BuildExpressionTasks(expression string) (*Expression, error) {
-	tokens, err := calculator.Tokenize(expression)
+	return submitExpression(context.Background(), &Expression{Expr: expression})
+}
+
+// submitExpression parses expr.Expr, builds the tree and generates tasks like BuildExpressionTasks.
+// The remaining fields of expr (e.g. the batch it belongs to) are kept as given.
+// ctx carries the attributes to log the submission with, such as the request id.
+// Every expression starts a new trace with a BuildExpressionTasks span.
+func submitExpression(ctx context.Context, expr *Expression) (_ *Expression, err error) {
+	ctx, span := tracer.Start(ctx, "BuildExpressionTasks",
+		trace.WithNewRoot(),
+		trace.WithAttributes(attribute.String("expression", expr.Expr)))
+	defer func() {
+		setSpanError(span, err)
+		span.End()
+	}()
+	tokens, err := calculator.Tokenize(expr.Expr)
 	if err != nil {
 		return nil, err
 	}
@@ -147,39 +221,98 @@ func BuildExpressionTasks(expression string) (*Expression, error) {
 		return nil, err
 	}
 	exprID := uuid.New().String()
-	expr := &Expression{
-		ID:     exprID,
-		Expr:   expression,
-		Status: "pending",
-	}
+	expr.ID = exprID
+	expr.Status = "pending"
+	expr.TraceParent = traceParent(ctx)
+	span.SetAttributes(attribute.String("expression_id", exprID))
+	builder := &taskBuilder{exprID: exprID, shared: make(map[string]string)}
 	if tree.IsLiteral {
 		expr.Status = "done"
 		expr.Result = &tree.Value
 	} else {
-		rootTaskID := createTasksFromNode(exprID, tree)
-		expr.RootTaskID = rootTaskID
+		expr.RootTaskID = builder.createTasksFromNode(tree)
 	}
+	// The expression and all of its tasks are stored at once, so that agents
+	// cannot take a task before the expression it belongs to is known.
 	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	logger.InfoContext(ctx, "expression submitted", "expression_id", exprID, "batch_id", expr.BatchID)
 	expressionsStore[exprID] = expr
-	storeMutex.Unlock()
+	expressionStatuses[expr.Status]++
+	indexExpression(expr, time.Now())
+	if expr.Status == "done" {
+		expressionEnded(expr)
+	} else {
+		saveExpression(expr)
+	}
+	for _, task := range builder.tasks {
+		addTask(task)
+	}
+	notifyTaskReady()
 	return expr, nil
 }
 
 // updateTaskDependencies checks whether the task's dependencies are ready and assigns their results.
 func updateTaskDependencies(task *Task) bool {
-	if task.DepTask1 != "" && task.Arg1 == nil {
-		depTask, exists := tasksStore[task.DepTask1]
+	for i, dep := range task.DepTasks {
+		if dep == "" || task.Args[i] != nil {
+			continue
+		}
+		depTask, exists := tasksStore[dep]
 		if !exists || depTask.Status != "done" {
 			return false
 		}
-		task.Arg1 = depTask.Result
+		task.Args[i] = depTask.Result
 	}
-	if task.DepTask2 != "" && task.Arg2 == nil {
-		depTask, exists := tasksStore[task.DepTask2]
-		if !exists || depTask.Status != "done" {
+	for _, arg := range task.Args {
+		if arg == nil {
 			return false
 		}
-		task.Arg2 = depTask.Result
 	}
-	return task.Arg1 != nil && task.Arg2 != nil
+	return true
+}
+
+// failExpression marks the expression as failed with the given reason and cancels
+// all of its tasks that have not finished yet. Caller must hold storeMutex.
+func failExpression(exprID, reason string) {
+	if expr, ok := expressionsStore[exprID]; ok {
+		setExpressionStatus(expr, "error")
+		expr.Error = reason
+		expressionEnded(expr)
+	}
+	cancelTasks(exprID)
+}
+
+// cancelTasks cancels all tasks of the expression that have not finished yet,
+// so they are no longer handed out and late results are rejected. Caller must hold storeMutex.
+func cancelTasks(exprID string) {
+	for _, id := range expressionTasks[exprID] {
+		if task := tasksStore[id]; task.Status == "pending" || task.Status == "running" {
+			setTaskStatus(task, "cancelled")
+			saveTask(task)
+		}
+	}
+}
+
+// deleteExpression removes the expression and all of its tasks. Caller must hold storeMutex.
+func deleteExpression(exprID string) {
+	logger.Info("expression deleted", "expression_id", exprID)
+	for _, id := range expressionTasks[exprID] {
+		task := tasksStore[id]
+		if task.Status == "running" {
+			untrackLease(task)
+		}
+		delete(tasksStore, id)
+		taskStatuses[task.Status]--
+		delete(dependents, id)
+		removeTask(id)
+	}
+	delete(expressionTasks, exprID)
+	delete(taskCounts, exprID)
+	expr := expressionsStore[exprID]
+	delete(expressionsStore, exprID)
+	expressionStatuses[expr.Status]--
+	removeExpression(exprID)
+	unindexExpression(expr)
+	publishEvent(exprID, expressionEvent{Name: "deleted", Data: map[string]any{"id": exprID}})
 }
@@ -0,0 +1,97 @@
+package orchestrator
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceParentHeader is the W3C Trace Context header carrying the span context to the agent and back.
+const traceParentHeader = "traceparent"
+
+// tracerProvider exports the spans of the orchestrator to OTEL_EXPORTER_OTLP_ENDPOINT.
+var tracerProvider = newTracerProvider(ServiceName, OTLPEndpoint)
+
+// tracer records the spans of expressions. Each expression is a trace: BuildExpressionTasks is its root,
+// with a span for every dispatch of a task and the agent's compute and submission spans below it.
+var tracer trace.Tracer = tracerProvider.Tracer("github.com/ZolotarevAlexandr/yl_sprint_2_final/orchestrator")
+
+// propagator carries span contexts in the traceparent header.
+var propagator = propagation.TraceContext{}
+
+// newTracerProvider returns a provider exporting spans of the service over OTLP/HTTP to the collector
+// at endpoint, e.g. "http://localhost:4318". Without an endpoint spans are still created, so that
+// trace context reaches the agents, but they are not exported.
+func newTracerProvider(service, endpoint string) *sdktrace.TracerProvider {
+	res := resource.NewSchemaless(attribute.String("service.name", service))
+	if endpoint == "" {
+		return sdktrace.NewTracerProvider(sdktrace.WithResource(res))
+	}
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("exporting spans", "error", err)
+	}))
+	exporter, err := otlptracehttp.New(context.Background(),
+		otlptracehttp.WithEndpointURL(strings.TrimSuffix(endpoint, "/")+"/v1/traces"))
+	if err != nil {
+		logger.Error("creating span exporter", "error", err)
+		return sdktrace.NewTracerProvider(sdktrace.WithResource(res))
+	}
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+}
+
+// traceParent returns the traceparent value of the span in ctx, or "" if there is none.
+func traceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get(traceParentHeader)
+}
+
+// contextWithTraceParent returns ctx with the span context of a traceparent value as its remote parent.
+func contextWithTraceParent(ctx context.Context, value string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier{traceParentHeader: value})
+}
+
+// setSpanError marks the span as failed with err; a nil err leaves the span as it is.
+func setSpanError(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// traceDispatch records the span of handing out the task, from the moment it was queued,
+// and keeps its span context for the agent. Caller must hold storeMutex.
+func traceDispatch(task *Task, queued, now time.Time) {
+	ctx := context.Background()
+	if expr, ok := expressionsStore[task.ExpressionID]; ok {
+		ctx = contextWithTraceParent(ctx, expr.TraceParent)
+	}
+	ctx, span := tracer.Start(ctx, "dispatch task",
+		trace.WithTimestamp(queued),
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("task_id", task.ID),
+			attribute.String("operation", task.Operator),
+			attribute.String("agent_id", task.AgentID),
+			attribute.Int("attempt", task.Attempts)))
+	span.End(trace.WithTimestamp(now))
+	task.TraceParent = traceParent(ctx)
+}
+
+// startResultSpan starts the span of recording a task result, a child of the agent's span
+// in the traceparent header (or gRPC metadata) of the request.
+func startResultSpan(ctx context.Context, traceParentValue, taskID string) trace.Span {
+	_, span := tracer.Start(contextWithTraceParent(ctx, traceParentValue), "record result",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("task_id", taskID)))
+	return span
+}
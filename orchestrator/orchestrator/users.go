@@ -0,0 +1,143 @@
+package orchestrator
+
+import (
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+const (
+	maxLoginLength     = 64
+	minPasswordLength  = 8
+	passwordIterations = 600000
+	passwordSaltLength = 16
+)
+
+// User is an account of the calculator. Expressions submitted by a user are visible only to them.
+type User struct {
+	ID           string `json:"id"`
+	Login        string `json:"login"`
+	PasswordHash string `json:"password_hash"` // see hashPassword
+}
+
+// usersStore holds the accounts by login. Access is guarded by storeMutex.
+var usersStore = make(map[string]*User)
+
+// hashPassword derives a PBKDF2-SHA256 hash of the password with a random salt,
+// encoded as "pbkdf2-sha256$<iterations>$<salt>$<hash>".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltLength)
+	rand.Read(salt)
+	hash, err := pbkdf2.Key(sha256.New, password, salt, passwordIterations, sha256.Size)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("pbkdf2-sha256$%d$%s$%s", passwordIterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+}
+
+// checkPassword reports whether the password matches a hash made by hashPassword.
+func checkPassword(password, encoded string) bool {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 4 || parts[0] != "pbkdf2-sha256" {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	hash, err := pbkdf2.Key(sha256.New, password, salt, iterations, len(want))
+	return err == nil && subtle.ConstantTimeCompare(hash, want) == 1
+}
+
+// credentials is the body of POST /api/v1/register and POST /api/v1/login.
+type credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+}
+
+// handleRegister handles POST /api/v1/register, creating an account.
+func handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" {
+		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	if utf8.RuneCountInString(req.Login) > maxLoginLength {
+		http.Error(w, "login too long", http.StatusUnprocessableEntity)
+		return
+	}
+	if utf8.RuneCountInString(req.Password) < minPasswordLength {
+		http.Error(w, "password too short", http.StatusUnprocessableEntity)
+		return
+	}
+	// Hashing is slow on purpose, so it's done outside of the lock
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		logger.ErrorContext(r.Context(), "hashing password", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if _, ok := usersStore[req.Login]; ok {
+		http.Error(w, "login already taken", http.StatusConflict)
+		return
+	}
+	user := &User{ID: uuid.New().String(), Login: req.Login, PasswordHash: hash}
+	usersStore[user.Login] = user
+	saveUser(user)
+	logger.InfoContext(r.Context(), "user registered", "user_id", user.ID)
+	json.NewEncoder(w).Encode(map[string]string{"status": "user registered"})
+}
+
+// handleLogin handles POST /api/v1/login, exchanging the login and password for a token.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Login == "" {
+		http.Error(w, "invalid data", http.StatusUnprocessableEntity)
+		return
+	}
+	storeMutex.Lock()
+	user, ok := usersStore[req.Login]
+	storeMutex.Unlock()
+	if !ok || !checkPassword(req.Password, user.PasswordHash) {
+		http.Error(w, "invalid login or password", http.StatusUnauthorized)
+		return
+	}
+	token, err := issueToken(user, time.Now())
+	if err != nil {
+		logger.ErrorContext(r.Context(), "issuing token", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
@@ -0,0 +1,241 @@
+package orchestrator
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// WebhookDelivery is a single attempt to deliver the completion webhook of an expression.
+type WebhookDelivery struct {
+	Attempt    int       `json:"attempt"`
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// webhookClient sends webhooks; a request that hangs counts as a failed attempt.
+// It connects only to addresses allowed by webhookAddressAllowed, whatever the callback URL
+// resolves or redirects to, and doesn't use a proxy, so that the check applies to the receiver itself.
+var webhookClient = &http.Client{
+	Timeout: time.Duration(WebhookTimeoutMs) * time.Millisecond,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Control: checkWebhookAddress}).DialContext,
+	},
+}
+
+var errForbiddenAddress = errors.New("callback address is not public")
+
+// reservedNetworks are special-purpose ranges not covered by the predicates of netip.Addr.
+var reservedNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+}
+
+// allowedWebhookNetworks are the non-public networks callback URLs may point to anyway.
+var allowedWebhookNetworks = parseNetworks(WebhookAllowedNetworks)
+
+// parseNetworks parses a comma-separated list of CIDR ranges, skipping invalid ones.
+func parseNetworks(list string) []netip.Prefix {
+	var networks []netip.Prefix
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		network, err := netip.ParsePrefix(item)
+		if err != nil {
+			logger.Warn("ignoring invalid network in WEBHOOK_ALLOWED_NETWORKS", "network", item, "error", err)
+			continue
+		}
+		networks = append(networks, network.Masked())
+	}
+	return networks
+}
+
+// webhookAddressAllowed reports whether webhooks may be sent to the address. Only public addresses
+// and those in WEBHOOK_ALLOWED_NETWORKS are, so that users can't make the orchestrator call internal
+// services: loopback, private networks or cloud metadata endpoints such as 169.254.169.254.
+func webhookAddressAllowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, network := range allowedWebhookNetworks {
+		if network.Contains(addr) {
+			return true
+		}
+	}
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkWebhookAddress refuses connections of webhookClient to addresses that are not allowed.
+// It runs on the resolved address of every connection, so DNS names and redirects can't bypass it.
+func checkWebhookAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !webhookAddressAllowed(addr) {
+		return fmt.Errorf("%w: %s", errForbiddenAddress, host)
+	}
+	return nil
+}
+
+// validCallbackURL reports whether the callback URL is an absolute http(s) URL. Hosts that are
+// IP addresses must be allowed by webhookAddressAllowed; names are checked once resolved.
+func validCallbackURL(callbackURL string) bool {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		return webhookAddressAllowed(addr)
+	}
+	return u.Hostname() != "localhost"
+}
+
+// scheduleWebhook starts delivering the final state of the expression to its callback URL.
+// Caller must hold storeMutex and save the expression afterwards.
+func scheduleWebhook(expr *Expression) {
+	if expr.CallbackURL == "" || expr.WebhookStatus != "" {
+		return
+	}
+	expr.WebhookStatus = "pending"
+	go deliverWebhook(expr.ID, expr.CallbackURL, webhookPayload(expr), 1)
+}
+
+// resumeWebhooks continues deliveries interrupted by a restart. Caller must hold storeMutex.
+func resumeWebhooks() {
+	for _, expr := range expressionsStore {
+		if expr.WebhookStatus == "pending" {
+			go deliverWebhook(expr.ID, expr.CallbackURL, webhookPayload(expr), len(expr.WebhookDeliveries)+1)
+		}
+	}
+}
+
+// webhookPayload is the body of the webhook: the expression as GET /api/v1/expressions/:id returns it.
+// Caller must hold storeMutex.
+func webhookPayload(expr *Expression) []byte {
+	payload, err := json.Marshal(map[string]any{"expression": expr})
+	if err != nil {
+		logger.Error("encoding webhook", "expression_id", expr.ID, "error", err)
+	}
+	return payload
+}
+
+// signWebhook returns the value of the X-Calculator-Signature header: the hex HMAC-SHA256 of the body.
+func signWebhook(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(WebhookSecret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook POSTs the payload until the receiver answers with 2xx, waiting twice as long
+// after every failed attempt, and gives up after WebhookMaxAttempts.
+func deliverWebhook(exprID, callbackURL string, payload []byte, attempt int) {
+	backoff := time.Duration(WebhookBackoffMs) * time.Millisecond << (attempt - 1)
+	for ; attempt <= WebhookMaxAttempts; attempt++ {
+		delivery := WebhookDelivery{Attempt: attempt, Time: time.Now()}
+		status, err := postWebhook(exprID, callbackURL, payload)
+		delivery.StatusCode = status
+		if err == nil && (status < 200 || status > 299) {
+			err = fmt.Errorf("receiver answered %d", status)
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		outcome := "pending"
+		switch {
+		case err == nil:
+			outcome = "delivered"
+			logger.Info("webhook delivered", "expression_id", exprID, "attempt", attempt)
+		case attempt == WebhookMaxAttempts:
+			outcome = "failed"
+			logger.Error("delivering webhook, giving up", "expression_id", exprID, "attempt", attempt, "error", err)
+		}
+		storeMutex.Lock()
+		if expr, ok := expressionsStore[exprID]; ok {
+			expr.WebhookDeliveries = append(expr.WebhookDeliveries, delivery)
+			expr.WebhookStatus = outcome
+			saveExpression(expr)
+		}
+		storeMutex.Unlock()
+		if outcome != "pending" {
+			return
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// postWebhook sends a single attempt and returns the status code of the receiver.
+func postWebhook(exprID, callbackURL string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, callbackURL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Calculator-Expression", exprID)
+	if WebhookSecret == "" {
+		// Set when the webhook was requested, but not after a restart
+		return 0, errors.New("WEBHOOK_SECRET is not set, webhooks can't be signed")
+	}
+	req.Header.Set("X-Calculator-Signature", signWebhook(payload))
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// handleExpressionWebhooks handles GET /api/v1/expressions/:id/webhooks, the delivery log of the expression.
+func handleExpressionWebhooks(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/expressions/"), "/webhooks")
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	expr, ok := ownedExpression(id, requestOwner(r))
+	if !ok {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	if expr.CallbackURL == "" {
+		http.Error(w, "expression has no callback_url", http.StatusNotFound)
+		return
+	}
+	status := expr.WebhookStatus
+	if status == "" {
+		// The expression hasn't finished yet
+		status = "waiting"
+	}
+	deliveries := expr.WebhookDeliveries
+	if deliveries == nil {
+		deliveries = []WebhookDelivery{}
+	}
+	json.NewEncoder(w).Encode(map[string]any{
+		"callback_url": expr.CallbackURL,
+		"status":       status,
+		"deliveries":   deliveries,
+	})
+}
@@ -0,0 +1,159 @@
+package orchestrator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/coder/websocket/wsjson"
+)
+
+// dialWebSocket opens an interactive connection to the test server.
+func dialWebSocket(t *testing.T, ctx context.Context, serverURL string) *websocket.Conn {
+	conn, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(serverURL, "http")+"/api/v1/ws", nil)
+	if err != nil {
+		t.Fatalf("failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.CloseNow() })
+	return conn
+}
+
+// readServerMessage reads the next message and decodes its JSON.
+func readServerMessage(t *testing.T, ctx context.Context, conn *websocket.Conn) map[string]any {
+	var msg map[string]any
+	if err := wsjson.Read(ctx, conn, &msg); err != nil {
+		t.Fatalf("failed to read message: %v", err)
+	}
+	return msg
+}
+
+// writeClientMessage sends a text message.
+func writeClientMessage(t *testing.T, ctx context.Context, conn *websocket.Conn, payload string) {
+	if err := conn.Write(ctx, websocket.MessageText, []byte(payload)); err != nil {
+		t.Fatalf("failed to write message: %v", err)
+	}
+}
+
+func TestWebSocketCalculate(t *testing.T) {
+	resetScheduler()
+	ts := httptest.NewServer(LoggingMiddleware(http.HandlerFunc(handleWebSocket)))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn := dialWebSocket(t, ctx, ts.URL)
+
+	writeClientMessage(t, ctx, conn, `{"type": "calculate", "expression": "2+", "request_id": "r0"}`)
+	msg := readServerMessage(t, ctx, conn)
+	if msg["type"] != "error" || msg["request_id"] != "r0" {
+		t.Fatalf("expected an error for an invalid expression, got %v", msg)
+	}
+
+	writeClientMessage(t, ctx, conn, `{"type": "calculate", "expression": "2+3", "request_id": "r1"}`)
+	msg = readServerMessage(t, ctx, conn)
+	if msg["type"] != "accepted" || msg["request_id"] != "r1" || msg["id"] == "" {
+		t.Fatalf("expected the expression to be accepted, got %v", msg)
+	}
+	id := msg["id"]
+
+	storeMutex.Lock()
+	task := takeTask(time.Now(), "")
+	storeMutex.Unlock()
+	if _, err := submitResult(task.ID, task.LeaseID, 5, ""); err != nil {
+		t.Fatalf("failed to submit result: %v", err)
+	}
+
+	msg = readServerMessage(t, ctx, conn)
+	if msg["type"] != "progress" || msg["id"] != id || msg["task_id"] != task.ID {
+		t.Fatalf("expected a progress event, got %v", msg)
+	}
+	msg = readServerMessage(t, ctx, conn)
+	expr, _ := msg["expression"].(map[string]any)
+	if msg["type"] != "done" || msg["id"] != id || expr["result"] != 5.0 {
+		t.Fatalf("expected the final result, got %v", msg)
+	}
+
+	writeClientMessage(t, ctx, conn, `{"type": "subscribe", "id": "missing", "request_id": "r2"}`)
+	msg = readServerMessage(t, ctx, conn)
+	if msg["type"] != "error" || msg["request_id"] != "r2" {
+		t.Fatalf("expected an error for an unknown expression, got %v", msg)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	resetScheduler()
+	ts := httptest.NewServer(http.HandlerFunc(handleWebSocket))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/ws"
+
+	origin := http.Header{"Origin": {"https://evil.example"}}
+	_, resp, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: origin})
+	if err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected a cross-origin page to be refused with %d, got %v", http.StatusForbidden, resp)
+	}
+
+	wsOriginPatterns = []string{"*.example"}
+	defer func() { wsOriginPatterns = nil }()
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: origin})
+	if err != nil {
+		t.Fatalf("expected an allowed origin to connect: %v", err)
+	}
+	conn.CloseNow()
+
+	conn, _, err = websocket.Dial(ctx, url, &websocket.DialOptions{HTTPHeader: http.Header{"Origin": {ts.URL}}})
+	if err != nil {
+		t.Fatalf("expected a same-origin page to connect: %v", err)
+	}
+	conn.CloseNow()
+}
+
+func TestWebSocketAccessToken(t *testing.T) {
+	resetScheduler()
+	usersStore = make(map[string]*User)
+	user := &User{ID: "alice-id", Login: "alice"}
+	usersStore[user.Login] = user
+	token, err := issueToken(user, time.Now())
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	ts := httptest.NewServer(AuthMiddleware(http.HandlerFunc(handleWebSocket)))
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/api/v1/ws"
+
+	_, resp, err := websocket.Dial(ctx, url, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected a connection without a token to be refused with %d, got %v", http.StatusUnauthorized, resp)
+	}
+
+	conn, _, err := websocket.Dial(ctx, url+"?access_token="+token, nil)
+	if err != nil {
+		t.Fatalf("expected the token in the query to be accepted: %v", err)
+	}
+	defer conn.CloseNow()
+	writeClientMessage(t, ctx, conn, `{"type": "calculate", "expression": "2+3", "request_id": "r1"}`)
+	msg := readServerMessage(t, ctx, conn)
+	if msg["type"] != "accepted" {
+		t.Fatalf("expected the expression to be accepted, got %v", msg)
+	}
+	storeMutex.Lock()
+	owner := expressionsStore[msg["id"].(string)].Owner
+	storeMutex.Unlock()
+	if owner != user.ID {
+		t.Errorf("expected the expression to belong to %q, got %q", user.ID, owner)
+	}
+
+	// Other endpoints only take the header
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/expressions?access_token="+token, nil)
+	AuthMiddleware(http.HandlerFunc(handleListExpressions)).ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d for a token in the query of another endpoint, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
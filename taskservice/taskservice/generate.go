@@ -0,0 +1,6 @@
+// Package taskservice is TaskService, the gRPC protocol between agents and the orchestrator.
+// The messages and the client and server stubs are generated from taskservice.proto
+// with protoc-gen-go and protoc-gen-go-grpc.
+package taskservice
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative taskservice.proto